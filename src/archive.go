@@ -0,0 +1,378 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File name suffixes of the archives looked into with -archives
+var archiveSuffixes = []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".zip", ".rpm", ".deb"}
+
+// Archives may hold JDKs for other systems than the one scanned
+var archiveLibJVMFileNames = []string{"libjvm.so", "libjvm.dylib"}
+
+// Files that the analysis of an installation reads. They are kept in
+// memory as the archive is read, so that it need not be read again.
+var archiveKeptFiles = map[string]bool{
+	"libjvm.so":      true,
+	"libjvm.dylib":   true,
+	"rt.jar":         true,
+	"java.base.jmod": true,
+	"release":        true,
+}
+
+var (
+	errZstd           = errors.New("zstd compression is not supported")
+	errNotRPM         = errors.New("not an rpm package")
+	errNotDeb         = errors.New("not a deb package")
+	errCorruptedCpio  = errors.New("corrupted cpio archive")
+	errUnknownArchive = errors.New("unknown archive format")
+)
+
+// FormatError tells that an archive or a snap image is in a format, or is
+// compressed in a way, that is not supported. Such files are reported as
+// skipped in the coverage of the scan.
+type FormatError struct {
+	Path   string
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return e.Path + ": " + e.Reason
+}
+
+// archiveError adds the path of an archive to an error reading it
+func archiveError(filePath string, e error) error {
+	if e == errZstd || e == errXZUnsupported {
+		return &FormatError{filePath, e.Error()}
+	}
+	return fmt.Errorf("%s: %s", filePath, e.Error())
+}
+
+// isArchive tells whether a file of the given name is an archive that may
+// hold a JDK
+func isArchive(name string) bool {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveEntries iterates the entries of an archive like tar.Reader does
+type archiveEntries interface {
+	Next() (*tar.Header, error)
+	Read(p []byte) (int, error)
+}
+
+// ScanArchive finds the installations held in an archive without
+// extracting or running anything
+func ScanArchive(filePath string, config *Config) ([]*JVMInstallation, error) {
+	f, e := os.Open(filePath)
+	if e != nil {
+		return nil, e
+	}
+	defer closeFile(f)
+	entries, e := openArchive(f, filePath)
+	if e != nil {
+		return nil, archiveError(filePath, e)
+	}
+
+	fsys := newMemFS(func(n *memNode) ([]byte, error) {
+		return readArchiveEntry(filePath, n.entry)
+	})
+	fsys.keep = func(name string) bool {
+		return archiveKeptFiles[name]
+	}
+	if e = fsys.applyLayer(entries, 0); e != nil {
+		return nil, archiveError(filePath, e)
+	}
+
+	noRun := *config
+	noRun.nojvmrun = true
+	installations := findInstallations(fsys, &noRun, archiveLibJVMFileNames...)
+	for _, inst := range installations {
+		inst.Kind = KIND_ARCHIVE
+		inst.ArchivePath = filePath
+	}
+	return installations, nil
+}
+
+// readArchiveEntry reads the archive again up to the given entry
+func readArchiveEntry(filePath string, name string) ([]byte, error) {
+	f, e := os.Open(filePath)
+	if e != nil {
+		return nil, e
+	}
+	defer closeFile(f)
+	entries, e := openArchive(f, filePath)
+	if e != nil {
+		return nil, e
+	}
+	for {
+		hdr, e := entries.Next()
+		if e == io.EOF {
+			return nil, os.ErrNotExist
+		}
+		if e != nil {
+			return nil, e
+		}
+		if path.Clean("/"+hdr.Name) == name {
+			return ioutil.ReadAll(throttled(entries))
+		}
+	}
+}
+
+// openArchive returns the entries of the archive in f, whose format is
+// told by the name
+func openArchive(f *os.File, name string) (archiveEntries, error) {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		fi, e := f.Stat()
+		if e != nil {
+			return nil, e
+		}
+		z, e := zip.NewReader(f, fi.Size())
+		if e != nil {
+			return nil, e
+		}
+		return &zipEntries{files: z.File}, nil
+	case strings.HasSuffix(name, ".rpm"):
+		payload, e := rpmPayload(bufio.NewReader(f))
+		if e != nil {
+			return nil, e
+		}
+		return &cpioReader{r: bufio.NewReader(payload)}, nil
+	case strings.HasSuffix(name, ".deb"):
+		data, e := debData(bufio.NewReader(f))
+		if e != nil {
+			return nil, e
+		}
+		return tar.NewReader(data), nil
+	case isArchive(name):
+		r, e := decompress(f)
+		if e != nil {
+			return nil, e
+		}
+		return tar.NewReader(r), nil
+	}
+	return nil, errUnknownArchive
+}
+
+// decompress returns the data of r decompressed according to its magic
+// number. Data in an unknown format is returned as it is.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.Equal(magic, xzMagic):
+		return newXZReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, errZstd
+	}
+	return br, nil
+}
+
+// rpmPayload skips the lead and the headers of an rpm package and returns
+// its payload, a cpio archive
+func rpmPayload(r *bufio.Reader) (io.Reader, error) {
+	lead := make([]byte, 96)
+	if _, e := io.ReadFull(r, lead); e != nil || !bytes.HasPrefix(lead, []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, errNotRPM
+	}
+	// The signature header is padded to a multiple of 8 bytes
+	for _, align := range []int{8, 1} {
+		h := make([]byte, 16)
+		if _, e := io.ReadFull(r, h); e != nil || !bytes.HasPrefix(h, []byte{0x8e, 0xad, 0xe8, 0x01}) {
+			return nil, errNotRPM
+		}
+		size := 16*int64(binary.BigEndian.Uint32(h[8:])) + int64(binary.BigEndian.Uint32(h[12:]))
+		size += (int64(align) - size%int64(align)) % int64(align)
+		if _, e := io.CopyN(ioutil.Discard, r, size); e != nil {
+			return nil, errNotRPM
+		}
+	}
+	return decompress(r)
+}
+
+// debData returns the data.tar member of a deb package, decompressed
+func debData(r *bufio.Reader) (io.Reader, error) {
+	magic := make([]byte, 8)
+	if _, e := io.ReadFull(r, magic); e != nil || string(magic) != "!<arch>\n" {
+		return nil, errNotDeb
+	}
+	h := make([]byte, 60)
+	for {
+		if _, e := io.ReadFull(r, h); e != nil {
+			return nil, errNotDeb
+		}
+		name := strings.TrimRight(string(h[:16]), " /")
+		size, e := strconv.ParseInt(strings.TrimSpace(string(h[48:58])), 10, 64)
+		if e != nil || size < 0 {
+			return nil, errNotDeb
+		}
+		if strings.HasPrefix(name, "data.tar") {
+			return decompress(io.LimitReader(r, size))
+		}
+		// Members are aligned to 2 bytes
+		if _, e := io.CopyN(ioutil.Discard, r, size+size%2); e != nil {
+			return nil, errNotDeb
+		}
+	}
+}
+
+// cpioReader reads a cpio archive in the "new ASCII" format used by rpm
+type cpioReader struct {
+	r         *bufio.Reader
+	remaining int64
+	pad       int64
+}
+
+func (c *cpioReader) Next() (*tar.Header, error) {
+	if _, e := io.CopyN(ioutil.Discard, c.r, c.remaining+c.pad); e != nil {
+		return nil, errCorruptedCpio
+	}
+	h := make([]byte, 110)
+	if _, e := io.ReadFull(c.r, h); e != nil {
+		return nil, errCorruptedCpio
+	}
+	if string(h[:6]) != "070701" && string(h[:6]) != "070702" {
+		return nil, errCorruptedCpio
+	}
+	field := func(i int) int64 {
+		v, _ := strconv.ParseInt(string(h[6+8*i:14+8*i]), 16, 64)
+		return v
+	}
+	mode, mtime, size, nameSize := field(1), field(5), field(6), field(11)
+	if nameSize < 1 || size < 0 {
+		return nil, errCorruptedCpio
+	}
+	name := make([]byte, nameSize+(4-(110+nameSize)%4)%4)
+	if _, e := io.ReadFull(c.r, name); e != nil {
+		return nil, errCorruptedCpio
+	}
+	hdr := &tar.Header{
+		Name:    string(name[:nameSize-1]),
+		Mode:    mode & 07777,
+		Size:    size,
+		ModTime: time.Unix(mtime, 0),
+	}
+	if hdr.Name == "TRAILER!!!" {
+		return nil, io.EOF
+	}
+	c.remaining, c.pad = size, (4-size%4)%4
+
+	switch mode & 0170000 {
+	case 0040000:
+		hdr.Typeflag = tar.TypeDir
+	case 0100000:
+		hdr.Typeflag = tar.TypeReg
+	case 0120000:
+		target, e := ioutil.ReadAll(c)
+		if e != nil {
+			return nil, errCorruptedCpio
+		}
+		hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, string(target), 0
+	default:
+		hdr.Typeflag = tar.TypeFifo
+	}
+	return hdr, nil
+}
+
+func (c *cpioReader) Read(p []byte) (int, error) {
+	if c.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, e := c.r.Read(p)
+	c.remaining -= int64(n)
+	if e == io.EOF {
+		e = io.ErrUnexpectedEOF
+	}
+	return n, e
+}
+
+// zipEntries iterates the entries of a zip archive
+type zipEntries struct {
+	files []*zip.File
+	next  int
+	file  *zip.File
+	rc    io.ReadCloser
+}
+
+func (z *zipEntries) Next() (*tar.Header, error) {
+	z.close()
+	if z.next == len(z.files) {
+		return nil, io.EOF
+	}
+	z.file = z.files[z.next]
+	z.next++
+
+	mode := z.file.Mode()
+	hdr := &tar.Header{
+		Name:    z.file.Name,
+		Mode:    int64(mode.Perm()),
+		Size:    int64(z.file.UncompressedSize64),
+		ModTime: z.file.Modified,
+	}
+	switch {
+	case mode.IsDir() || strings.HasSuffix(z.file.Name, "/"):
+		hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+	case mode&os.ModeSymlink != 0:
+		target, e := ioutil.ReadAll(io.LimitReader(z, 4096))
+		if e != nil {
+			return nil, e
+		}
+		hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, string(target), 0
+	default:
+		hdr.Typeflag = tar.TypeReg
+	}
+	return hdr, nil
+}
+
+func (z *zipEntries) Read(p []byte) (int, error) {
+	if z.file == nil {
+		return 0, io.EOF
+	}
+	if z.rc == nil {
+		rc, e := z.file.Open()
+		if e != nil {
+			return 0, e
+		}
+		z.rc = rc
+	}
+	return z.rc.Read(p)
+}
+
+func (z *zipEntries) close() {
+	if z.rc != nil {
+		_ = z.rc.Close()
+		z.rc = nil
+	}
+	z.file = nil
+}
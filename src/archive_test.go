@@ -0,0 +1,82 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// The packages of testdata/archives hold a Zulu 11 with a release file and
+// a libjvm of strings. The debs were built by dpkg-deb -Z<compressor>, the
+// rpms by hand as rpmbuild would, with gzip, xz or zstd payloads.
+const archiveFixtureLibJVM = "/usr/lib/jvm/zulu11/lib/server/libjvm.so"
+
+func scanArchive(p string) (installations []*JVMInstallation, e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return ScanArchive(p, &Config{})
+}
+
+func TestScanArchive(t *testing.T) {
+	for _, name := range []string{"zulu11-gzip.deb", "zulu11-xz.deb", "zulu11-gzip.rpm", "zulu11-xz.rpm"} {
+		p := "testdata/archives/" + name
+		installations, e := scanArchive(p)
+		if e != nil {
+			t.Errorf("%s: %v", name, e)
+			continue
+		}
+		if len(installations) != 1 {
+			t.Errorf("%s: got %d installations", name, len(installations))
+			continue
+		}
+		inst := installations[0]
+		if inst.LibJVM != archiveFixtureLibJVM || inst.ArchivePath != p || inst.Kind != KIND_ARCHIVE {
+			t.Errorf("%s: got %s in %s", name, inst.LibJVM, inst.ArchivePath)
+		}
+		if inst.VersionInfo.Version != "11.0.12" || inst.VersionInfo.VMName != "OpenJDK 64-Bit Server VM" {
+			t.Errorf("%s: got version %q of %q", name, inst.VersionInfo.Version, inst.VersionInfo.VMName)
+		}
+	}
+}
+
+// zstd is what RHEL 9 compresses rpms with, and Ubuntu 21.10 and later debs
+func TestScanArchiveZstd(t *testing.T) {
+	for _, name := range []string{"zulu11-zstd.deb", "zulu11-zstd.rpm"} {
+		p := "testdata/archives/" + name
+		_, e := scanArchive(p)
+		if fe, ok := e.(*FormatError); !ok || fe.Path != p || fe.Reason != errZstd.Error() {
+			t.Errorf("%s: got error %v, want a FormatError", name, e)
+		}
+	}
+}
+
+func TestScanArchiveCorrupted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"zulu11-gzip.deb", "zulu11-xz.deb", "zulu11-gzip.rpm", "zulu11-xz.rpm"} {
+		data, e := ioutil.ReadFile("testdata/archives/" + name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		p := path.Join(dir, name)
+		for _, corrupted := range [][]byte{data[:len(data)/2], data[:100], []byte("junk")} {
+			if e := ioutil.WriteFile(p, corrupted, 0644); e != nil {
+				t.Fatal(e)
+			}
+			if _, e := scanArchive(p); e == nil {
+				t.Errorf("%s cut to %d bytes: got no error", name, len(corrupted))
+			} else if _, ok := e.(*FormatError); ok {
+				t.Errorf("%s cut to %d bytes: got %v, want a read error", name, len(corrupted), e)
+			}
+		}
+	}
+}
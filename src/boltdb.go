@@ -0,0 +1,239 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+)
+
+// BoltDB reads a bbolt database file, as used by containerd for its
+// metadata. Only what is needed to look up keys is implemented, and the
+// whole file is read into memory.
+type BoltDB struct {
+	data     []byte
+	pageSize int
+	root     uint64
+}
+
+// BoltBucket is a bucket of a BoltDB. A bucket is either stored in its own
+// pages or inline in the value of its parent.
+type BoltBucket struct {
+	db     *BoltDB
+	root   uint64
+	inline []byte
+}
+
+// Page layout of bbolt
+const (
+	boltPageHeaderSize = 16
+	boltElementSize    = 16
+	boltBranchPage     = 0x01
+	boltLeafPage       = 0x02
+	boltMetaPage       = 0x04
+	boltBucketLeafFlag = 0x01
+	boltMagic          = 0xED0CDAED
+	boltVersion        = 2
+	boltMetaSize       = 64
+)
+
+var errBoltCorrupted = errors.New("corrupted bolt database")
+
+func OpenBoltDB(filePath string) (*BoltDB, error) {
+	data, e := ioutil.ReadFile(filePath)
+	if e != nil {
+		return nil, e
+	}
+	db := &BoltDB{data: data}
+
+	// The two meta pages alternate between transactions; use the most
+	// recent valid one. The page size is only known from the first one.
+	meta, ok := db.readMeta(0)
+	if !ok || meta.pageSize < boltPageHeaderSize+boltMetaSize {
+		return nil, errBoltCorrupted
+	}
+	if other, ok := db.readMeta(meta.pageSize); ok && other.txid > meta.txid {
+		meta = other
+	}
+	db.pageSize, db.root = meta.pageSize, meta.root
+	return db, nil
+}
+
+type boltMeta struct {
+	pageSize int
+	root     uint64
+	txid     uint64
+}
+
+func (db *BoltDB) readMeta(offset int) (boltMeta, bool) {
+	start := offset + boltPageHeaderSize
+	if start+boltMetaSize > len(db.data) {
+		return boltMeta{}, false
+	}
+	flags := binary.LittleEndian.Uint16(db.data[offset+8:])
+	m := db.data[start : start+boltMetaSize]
+	if flags != boltMetaPage || binary.LittleEndian.Uint32(m[0:]) != boltMagic || binary.LittleEndian.Uint32(m[4:]) != boltVersion {
+		return boltMeta{}, false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(m[:56])
+	if h.Sum64() != binary.LittleEndian.Uint64(m[56:]) {
+		return boltMeta{}, false
+	}
+	return boltMeta{
+		pageSize: int(binary.LittleEndian.Uint32(m[8:])),
+		root:     binary.LittleEndian.Uint64(m[16:]),
+		txid:     binary.LittleEndian.Uint64(m[48:]),
+	}, true
+}
+
+// Root returns the bucket holding all the top-level buckets
+func (db *BoltDB) Root() *BoltBucket {
+	return &BoltBucket{db: db, root: db.root}
+}
+
+// Bucket returns the nested bucket found by following the given names, or
+// nil if there is none
+func (b *BoltBucket) Bucket(names ...string) *BoltBucket {
+	for _, name := range names {
+		if b == nil {
+			return nil
+		}
+		var next *BoltBucket
+		_ = b.ForEach(func(k []byte, v []byte, bucket bool) error {
+			if bucket && string(k) == name {
+				next = b.db.bucket(v)
+				return errStopIteration
+			}
+			return nil
+		})
+		b = next
+	}
+	return b
+}
+
+// ForEachBucket calls fn for every nested bucket
+func (b *BoltBucket) ForEachBucket(fn func(name string, nested *BoltBucket)) {
+	_ = b.ForEach(func(k []byte, v []byte, bucket bool) error {
+		if bucket {
+			if nested := b.db.bucket(v); nested != nil {
+				fn(string(k), nested)
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the value of a key, or nil if there is none
+func (b *BoltBucket) Get(key string) []byte {
+	var value []byte
+	_ = b.ForEach(func(k []byte, v []byte, bucket bool) error {
+		if !bucket && string(k) == key {
+			value = v
+			return errStopIteration
+		}
+		return nil
+	})
+	return value
+}
+
+var errStopIteration = errors.New("stop iteration")
+
+// ForEach calls fn for every key of the bucket in order. Iteration stops
+// at the first error fn returns. A nil bucket has no keys.
+func (b *BoltBucket) ForEach(fn func(k []byte, v []byte, bucket bool) error) error {
+	if b == nil {
+		return nil
+	}
+	var e error
+	if b.root == 0 {
+		e = b.db.forEachInPage(b.inline, fn)
+	} else {
+		e = b.db.forEachInPageID(b.root, fn, 0)
+	}
+	if e == errStopIteration {
+		return nil
+	}
+	return e
+}
+
+func (db *BoltDB) bucket(value []byte) *BoltBucket {
+	if len(value) < 16 {
+		return nil
+	}
+	root := binary.LittleEndian.Uint64(value)
+	if root == 0 {
+		return &BoltBucket{db: db, inline: value[16:]}
+	}
+	return &BoltBucket{db: db, root: root}
+}
+
+func (db *BoltDB) page(id uint64) ([]byte, error) {
+	offset := int(id) * db.pageSize
+	if id == 0 || offset+boltPageHeaderSize > len(db.data) || offset < 0 {
+		return nil, errBoltCorrupted
+	}
+	overflow := int(binary.LittleEndian.Uint32(db.data[offset+12:]))
+	end := offset + (overflow+1)*db.pageSize
+	if end > len(db.data) {
+		end = len(db.data)
+	}
+	return db.data[offset:end], nil
+}
+
+func (db *BoltDB) forEachInPageID(id uint64, fn func(k []byte, v []byte, bucket bool) error, depth int) error {
+	if depth > 64 {
+		return errBoltCorrupted
+	}
+	p, e := db.page(id)
+	if e != nil {
+		return e
+	}
+	if binary.LittleEndian.Uint16(p[8:]) != boltBranchPage {
+		return db.forEachInPage(p, fn)
+	}
+	count := int(binary.LittleEndian.Uint16(p[10:]))
+	for i := 0; i < count; i++ {
+		elem := boltPageHeaderSize + i*boltElementSize
+		if elem+boltElementSize > len(p) {
+			return errBoltCorrupted
+		}
+		child := binary.LittleEndian.Uint64(p[elem+8:])
+		if e := db.forEachInPageID(child, fn, depth+1); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// forEachInPage iterates the elements of a leaf page
+func (db *BoltDB) forEachInPage(p []byte, fn func(k []byte, v []byte, bucket bool) error) error {
+	if len(p) < boltPageHeaderSize {
+		return errBoltCorrupted
+	}
+	if binary.LittleEndian.Uint16(p[8:]) != boltLeafPage {
+		return errBoltCorrupted
+	}
+	count := int(binary.LittleEndian.Uint16(p[10:]))
+	for i := 0; i < count; i++ {
+		elem := boltPageHeaderSize + i*boltElementSize
+		if elem+boltElementSize > len(p) {
+			return errBoltCorrupted
+		}
+		flags := binary.LittleEndian.Uint32(p[elem:])
+		pos := elem + int(binary.LittleEndian.Uint32(p[elem+4:]))
+		ksize := int(binary.LittleEndian.Uint32(p[elem+8:]))
+		vsize := int(binary.LittleEndian.Uint32(p[elem+12:]))
+		if pos+ksize+vsize > len(p) {
+			return errBoltCorrupted
+		}
+		if e := fn(p[pos:pos+ksize], p[pos+ksize:pos+ksize+vsize], flags&boltBucketLeafFlag != 0); e != nil {
+			return e
+		}
+	}
+	return nil
+}
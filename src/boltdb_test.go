@@ -0,0 +1,125 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// testdata/bolt.db was written by bbolt 1.3 with 4KiB pages, in four
+// transactions:
+//
+//  1. bucket "state" with version=old
+//  2. bucket "images" with 40 buckets named docker.io/library/appNNN:latest,
+//     each with a digest and a mediatype; the small buckets are inline in
+//     their parent, which needs a branch page
+//  3. version=old, in meta page 0
+//  4. version=new, in meta page 1
+const boltFixture = "testdata/bolt.db"
+
+func openBoltFixture(t *testing.T) *BoltDB {
+	db, e := OpenBoltDB(boltFixture)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return db
+}
+
+func TestBoltDBNewerMeta(t *testing.T) {
+	db := openBoltFixture(t)
+	if v := db.Root().Bucket("state").Get("version"); string(v) != "new" {
+		t.Errorf("got version %q", v)
+	}
+}
+
+func TestBoltDBCorruptedMeta(t *testing.T) {
+	data, e := ioutil.ReadFile(boltFixture)
+	if e != nil {
+		t.Fatal(e)
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "bolt.db")
+
+	// A torn write of the newer meta page leaves the older one
+	torn := append([]byte(nil), data...)
+	torn[4096+boltPageHeaderSize+56] ^= 0xff
+	if e := ioutil.WriteFile(p, torn, 0644); e != nil {
+		t.Fatal(e)
+	}
+	db, e := OpenBoltDB(p)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v := db.Root().Bucket("state").Get("version"); string(v) != "old" {
+		t.Errorf("got version %q", v)
+	}
+	if db.Root().Bucket("images", "docker.io/library/app000:latest") == nil {
+		t.Error("got no image bucket")
+	}
+
+	// The page size is only known from the first meta page
+	torn = append([]byte(nil), data...)
+	torn[boltPageHeaderSize+56] ^= 0xff
+	if e := ioutil.WriteFile(p, torn, 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := OpenBoltDB(p); e != errBoltCorrupted {
+		t.Errorf("got error %v, want %v", e, errBoltCorrupted)
+	}
+}
+
+func TestBoltDBBranchPage(t *testing.T) {
+	db := openBoltFixture(t)
+	images := db.Root().Bucket("images")
+	if images == nil {
+		t.Fatal("got no images bucket")
+	}
+	p, e := db.page(images.root)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if flags := binary.LittleEndian.Uint16(p[8:]); flags != boltBranchPage {
+		t.Fatalf("got page flags %#x, want a branch page", flags)
+	}
+
+	var names []string
+	images.ForEachBucket(func(name string, nested *BoltBucket) {
+		names = append(names, name)
+	})
+	if len(names) != 40 {
+		t.Fatalf("got %d image buckets", len(names))
+	}
+	for i, name := range names {
+		if want := fmt.Sprintf("docker.io/library/app%03d:latest", i); name != want {
+			t.Errorf("got bucket %q, want %q", name, want)
+		}
+	}
+}
+
+func TestBoltDBInlineBucket(t *testing.T) {
+	db := openBoltFixture(t)
+	i := 0
+	db.Root().Bucket("images").ForEachBucket(func(name string, nested *BoltBucket) {
+		if nested.root != 0 || nested.inline == nil {
+			t.Errorf("%s: got bucket in page %d, want it inline", name, nested.root)
+		}
+		if digest, want := string(nested.Get("digest")), fmt.Sprintf("sha256:%064x", i); digest != want {
+			t.Errorf("%s: got digest %q, want %q", name, digest, want)
+		}
+		if nested.Get("missing") != nil {
+			t.Errorf("%s: got a value for a missing key", name)
+		}
+		i++
+	})
+	if db.Root().Bucket("images", "docker.io/library/app999:latest") != nil {
+		t.Error("got a bucket for a missing image")
+	}
+}
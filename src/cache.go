@@ -0,0 +1,179 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cacheAnalysis is raised whenever installations are analysed in more
+// detail, so that the ones cached by older versions are analysed again
+const cacheAnalysis = 11
+
+// CacheKey identifies a libjvm file, or an archive. An installation is only
+// re-analysed when one of these has changed since the previous scan.
+type CacheKey struct {
+	LibJVM string `json:"libjvm"`
+	Inode  uint64 `json:"inode"`
+	Size   int64  `json:"size"`
+	MTime  int64  `json:"mtime"`
+	// Installations analysed with and without java -version differ
+	JVMRun bool `json:"jvmrun"`
+	// and so do the paths reported with -sysroot, the disagreements found
+	// with -crosscheck, and the versions found by other -detectors
+	Sysroot    string `json:"sysroot,omitempty"`
+	CrossCheck bool   `json:"crosscheck,omitempty"`
+	Detectors  string `json:"detectors,omitempty"`
+	Analysis   int    `json:"analysis,omitempty"`
+}
+
+// cacheEntry holds one installation of a key, or none for archives found
+// to hold no installation
+type cacheEntry struct {
+	Key          CacheKey         `json:"key"`
+	Installation *JVMInstallation `json:"installation"`
+}
+
+// InstallationCache keeps finished JVMInstallations between scans.
+// It is safe for concurrent use.
+type InstallationCache struct {
+	lock     sync.Mutex
+	filePath string
+	previous map[CacheKey][]*JVMInstallation
+	current  []cacheEntry
+}
+
+// LoadInstallationCache reads the cache left by the previous scan. A missing
+// or damaged cache file results in an empty cache.
+func LoadInstallationCache(config *Config) *InstallationCache {
+	c := &InstallationCache{
+		filePath: config.CacheFilePath(),
+		previous: make(map[CacheKey][]*JVMInstallation),
+	}
+	if config.full {
+		return c
+	}
+
+	f, e := os.Open(c.filePath)
+	if e != nil {
+		return c
+	}
+	defer closeFile(f)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry cacheEntry
+		if e := json.Unmarshal(scanner.Bytes(), &entry); e != nil {
+			continue
+		}
+		installations := c.previous[entry.Key]
+		if entry.Installation != nil {
+			installations = append(installations, entry.Installation)
+		}
+		c.previous[entry.Key] = installations
+	}
+	return c
+}
+
+func NewCacheKey(libjvm string, config *Config) (CacheKey, error) {
+	info, e := os.Stat(libjvm)
+	if e != nil {
+		return CacheKey{}, e
+	}
+	key := CacheKey{
+		LibJVM:     libjvm,
+		Size:       info.Size(),
+		MTime:      info.ModTime().UnixNano(),
+		JVMRun:     !config.nojvmrun,
+		Sysroot:    config.sysroot,
+		CrossCheck: config.crosscheck,
+		Detectors:  strings.Join(config.detectors, ","),
+		Analysis:   cacheAnalysis,
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		key.Inode = uint64(st.Ino)
+	}
+	return key, nil
+}
+
+// Lookup returns copies of the installations analysed by the previous
+// scan, and false if there are none for this key
+func (c *InstallationCache) Lookup(key CacheKey) ([]*JVMInstallation, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	installations, ok := c.previous[key]
+	var res []*JVMInstallation
+	for _, inst := range installations {
+		copied := *inst
+		res = append(res, &copied)
+	}
+	return res, ok
+}
+
+// Store remembers the installations found by the current scan
+func (c *InstallationCache) Store(key CacheKey, installations ...*JVMInstallation) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.current = append(c.current, cacheEntries(key, installations)...)
+}
+
+func cacheEntries(key CacheKey, installations []*JVMInstallation) []cacheEntry {
+	if len(installations) == 0 {
+		return []cacheEntry{{key, nil}}
+	}
+	var entries []cacheEntry
+	for _, inst := range installations {
+		entries = append(entries, cacheEntry{key, inst})
+	}
+	return entries
+}
+
+// Save replaces the cache file with the installations found by the
+// current scan. If keepPrevious is set, the installations of the previous
+// scan that the current one has not got to are kept as well.
+func (c *InstallationCache) Save(keepPrevious bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entries := c.current
+	if keepPrevious {
+		seen := make(map[string]bool)
+		for _, entry := range c.current {
+			seen[entry.Key.LibJVM] = true
+		}
+		for key, installations := range c.previous {
+			if !seen[key.LibJVM] {
+				entries = append(entries, cacheEntries(key, installations)...)
+			}
+		}
+	}
+
+	tmpPath := c.filePath + ".tmp"
+	f, e := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		if txt, e := json.Marshal(entry); e == nil {
+			_, _ = fmt.Fprintln(w, string(txt))
+		}
+	}
+	if e = w.Flush(); e != nil {
+		closeFile(f)
+		return e
+	}
+	if e = f.Close(); e != nil {
+		return e
+	}
+	return os.Rename(tmpPath, c.filePath)
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Checkpoint records how far an interrupted scan has got, so that
+// start -resume can continue it instead of starting from zero
+type Checkpoint struct {
+	ScanSet  string           `json:"scan_set"`
+	Walks    []WalkCheckpoint `json:"walks,omitempty"`
+	Pending  []string         `json:"pending,omitempty"`
+	Coverage *Coverage        `json:"coverage,omitempty"`
+	Progress *Progress        `json:"progress,omitempty"`
+}
+
+// WalkCheckpoint is the position of the walk of one walk root
+type WalkCheckpoint struct {
+	Root string `json:"root"`
+	Last string `json:"last,omitempty"`
+	Done bool   `json:"done,omitempty"`
+}
+
+// WalkPosition tracks how far a walk has got in its depth-first order.
+// Entries are walked sorted by name, so the last completely processed path
+// is enough to tell what remains to be done.
+type WalkPosition struct {
+	lock sync.Mutex
+	last string
+	done bool
+
+	// Paths up to this one have been processed by the interrupted scan
+	resume string
+}
+
+func newWalkPosition(wc WalkCheckpoint) *WalkPosition {
+	return &WalkPosition{last: wc.Last, done: wc.Done, resume: wc.Last}
+}
+
+func (pos *WalkPosition) advance(p string) {
+	pos.lock.Lock()
+	pos.last = p
+	pos.lock.Unlock()
+}
+
+func (pos *WalkPosition) finish() {
+	pos.lock.Lock()
+	pos.done = true
+	pos.lock.Unlock()
+}
+
+func (pos *WalkPosition) isDone() bool {
+	pos.lock.Lock()
+	defer pos.lock.Unlock()
+	return pos.done
+}
+
+func (pos *WalkPosition) checkpoint(root string) WalkCheckpoint {
+	pos.lock.Lock()
+	defer pos.lock.Unlock()
+	return WalkCheckpoint{Root: root, Last: pos.last, Done: pos.done}
+}
+
+// skip tells whether p has been processed by the interrupted scan. Only
+// the goroutine walking the position may call it.
+func (pos *WalkPosition) skip(p string) bool {
+	if pos.resume == "" {
+		return false
+	}
+	if p == pos.resume {
+		return true
+	}
+	if isSubPath(pos.resume, p) {
+		// Partially processed directory
+		return false
+	}
+	if walkedBefore(p, pos.resume) {
+		return true
+	}
+	// Everything from here on is new
+	pos.resume = ""
+	return false
+}
+
+// walkedBefore reports whether a comes before b in the depth-first order of
+// the walker, given that a is not an ancestor of b
+func walkedBefore(a string, b string) bool {
+	pa, pb := splitPath(a), splitPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] != pb[i] {
+			return pa[i] < pb[i]
+		}
+	}
+	return len(pa) < len(pb)
+}
+
+// LoadCheckpoint reads the checkpoint of the interrupted scan. It fails if
+// there is none or it was taken with another scan set.
+func LoadCheckpoint(config *Config) (*Checkpoint, error) {
+	data, e := ioutil.ReadFile(config.CheckpointFilePath())
+	if os.IsNotExist(e) {
+		return nil, errors.New("no interrupted scan to resume")
+	}
+	if e != nil {
+		return nil, e
+	}
+	cp := &Checkpoint{}
+	if e = json.Unmarshal(data, cp); e != nil {
+		return nil, fmt.Errorf("damaged checkpoint: %s", e.Error())
+	}
+	if cp.ScanSet != scanSetArgs(config.args) {
+		return nil, fmt.Errorf("cannot resume a scan of %s with %s", cp.ScanSet, scanSetArgs(config.args))
+	}
+	return cp, nil
+}
+
+// Save replaces the checkpoint file at once
+func (cp *Checkpoint) Save(config *Config) error {
+	txt, e := json.Marshal(cp)
+	if e != nil {
+		return e
+	}
+	filePath := config.CheckpointFilePath()
+	if e = ioutil.WriteFile(filePath+".tmp", txt, 0600); e != nil {
+		return e
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
+
+func RemoveCheckpoint(config *Config) {
+	_ = os.Remove(config.CheckpointFilePath())
+}
+
+// reopenOutput keeps the installations the interrupted scan has written to
+// the report and opens it for appending. A line cut short by the
+// interruption is dropped. It returns the libjvm files and archives the
+// kept installations were found through.
+func reopenOutput(config *Config) (*os.File, map[string]bool, error) {
+	lines, e := readReportLines(config.OutputFilePath())
+	if e != nil && !os.IsNotExist(e) {
+		return nil, nil, e
+	}
+	written := make(map[string]bool)
+	for _, line := range lines {
+		written[line.source] = true
+	}
+	if e = writeReportLines(config.OutputFilePath(), lines); e != nil {
+		return nil, nil, e
+	}
+	f, e := os.OpenFile(config.OutputFilePath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, nil, e
+	}
+	return f, written, nil
+}
+
+// sortReport orders the report by libjvm path, so that it does not depend
+// on the order the installations were found in, nor on whether the scan
+// was resumed. Installations in archives are ordered by archive path.
+func sortReport(config *Config) error {
+	lines, e := readReportLines(config.OutputFilePath())
+	if e != nil {
+		return e
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].key < lines[j].key
+	})
+	return writeReportLines(config.OutputFilePath(), lines)
+}
+
+type reportLine struct {
+	key    string
+	source string
+	txt    string
+}
+
+// readReportLines returns the valid lines of the report, one per
+// installation
+func readReportLines(filePath string) ([]reportLine, error) {
+	f, e := os.Open(filePath)
+	if e != nil {
+		return nil, e
+	}
+	defer closeFile(f)
+
+	var lines []reportLine
+	keys := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var info JVMInstallation
+		if e := json.Unmarshal(scanner.Bytes(), &info); e != nil || info.LibJVM == "" || keys[info.key()] {
+			continue
+		}
+		keys[info.key()] = true
+		lines = append(lines, reportLine{info.key(), info.source(), scanner.Text()})
+	}
+	return lines, scanner.Err()
+}
+
+func writeReportLines(filePath string, lines []reportLine) error {
+	f, e := os.Create(filePath + ".tmp")
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		_, _ = fmt.Fprintln(w, line.txt)
+	}
+	if e = w.Flush(); e != nil {
+		closeFile(f)
+		return e
+	}
+	if e = f.Close(); e != nil {
+		return e
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
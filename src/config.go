@@ -16,28 +16,61 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 )
 
 type CommandType string
 
 const (
-	CMD_START  CommandType = "start"
-	CMD_STOP   CommandType = "stop"
-	CMD_STATUS CommandType = "status"
-	CMD_REPORT CommandType = "report"
+	CMD_START      CommandType = "start"
+	CMD_STOP       CommandType = "stop"
+	CMD_STATUS     CommandType = "status"
+	CMD_REPORT     CommandType = "report"
+	CMD_COVERAGE   CommandType = "coverage"
+	CMD_SCAN_IMAGE CommandType = "scan-image"
 )
 
+// Network and pseudo filesystems that are not worth walking by default
+const defaultSkipFS = "nfs,cifs,smb,tmp,proc,sysfs,devtmpfs,devpts,cgroup,securityfs,debugfs,tracefs," +
+	"pstore,bpf,mqueue,hugetlbfs,configfs,autofs,fusectl,binfmt_misc"
+
 type Config struct {
 	libjvmFileName string
 	nojvmrun       bool
+	crosscheck     bool
+	detectors      []string     // names of the version detectors in order, all if empty
+	olderThan      *JavaVersion // only report installations older than this, if set
 	json           bool
 	csv            bool
 	skipfs         []string
-	root           string
+	skipmount      []string
+	onefs          bool
+	sysroot        string   // where the scanned system is mounted, if not at /
+	roots          []string // on the host, below the sysroot if any
+	exclude        []string
+	include        []string
+	filter         *PathFilter
+	args           []string
 	command        CommandType
 	cookie         string
 	wait           bool
+	follow         bool
 	logdir         string
+	workers        int
+	full           bool
+	dryrun         bool
+	resume         bool
+	quick          bool
+	archives       bool
+	index          IndexType
+	iorate         float64 // MB/s, unlimited if 0
+	nice           int
+	setNice        bool
+	ionice         int // ioprio_set(2) value, unchanged if -1
+	maxdepth       int
+	maxfiles       int64
+	timeout        time.Duration
+	imageFile      string
 }
 
 func (c *Config) OutputFilePath() string {
@@ -48,20 +81,86 @@ func (c *Config) ErrorFilePath() string {
 	return path.Join(c.logdir, "jdowser.err")
 }
 
+func (c *Config) CacheFilePath() string {
+	return path.Join(c.logdir, "jdowser.cache")
+}
+
+func (c *Config) CheckpointFilePath() string {
+	return path.Join(c.logdir, "jdowser.checkpoint")
+}
+
 func (c *Config) StatusFilePath() string {
 	return path.Join(c.logdir, "jdowser.status")
 }
 
+// fileSystem returns the file system of the scanned system
+func (c *Config) fileSystem() FileSystem {
+	return sysrootFS(c.sysroot)
+}
+
+// hostPath returns where a path of the scanned system is on the host
+func (c *Config) hostPath(p string) string {
+	if c.sysroot == "" {
+		return p
+	}
+	return path.Join(c.sysroot, p)
+}
+
+// systemPath returns the path of the scanned system of a path of the host,
+// which is the way installations are reported
+func (c *Config) systemPath(p string) string {
+	if c.sysroot == "" || !isSubPath(p, c.sysroot) {
+		return p
+	}
+	return path.Join("/", strings.TrimPrefix(p, c.sysroot))
+}
+
+// evalSymlinks is filepath.EvalSymlinks, except that the links met below
+// the sysroot are resolved inside of it
+func (c *Config) evalSymlinks(p string) (string, error) {
+	if c.sysroot == "" || !isSubPath(p, c.sysroot) {
+		return filepath.EvalSymlinks(p)
+	}
+	resolved, e := rootFS{c.sysroot}.resolve(c.systemPath(p), true)
+	if e != nil {
+		return "", &os.PathError{Op: "lstat", Path: p, Err: e}
+	}
+	return c.hostPath(resolved), nil
+}
+
 func InitConfig() *Config {
 	config := Config{}
 	config.libjvmFileName = getLibJVMFileName()
 
 	outjson := flag.Bool("json", false, "dump output in JSON format")
 	outcsv := flag.Bool("csv", false, "dump output in CSV format")
-	root := flag.String("root", "/", "root scan directory")
-	skipfs := flag.String("skipfs", "nfs,tmp,proc", "list of filesystem types to skip.")
+	var roots, exclude, include stringList
+	flag.Var(&roots, "root", "root scan directory, may be repeated (default /)")
+	flag.Var(&exclude, "exclude", "glob pattern of paths not to scan, may be repeated")
+	flag.Var(&include, "include", "glob pattern of paths to scan exclusively, may be repeated")
+	skipfs := flag.String("skipfs", defaultSkipFS, "list of filesystem types to skip.")
+	skipmount := flag.String("skipmount", "", "list of mount points to skip")
+	onefs := flag.Bool("onefs", false, "do not descend into other filesystems than the one of the root")
+	sysroot := flag.String("sysroot", "", "scan the system mounted at this directory, such as a disk image or a chroot")
 	nojvmrun := flag.Bool("nojvmrun", false, "do not run java -version to detect version")
+	crosscheck := flag.Bool("crosscheck", false, "detect versions in every available way and report disagreements")
+	detectors := flag.String("detectors", "", "version detectors to run, in order: exec, release, rtjar, jmod, jimage, strings")
 	wait := flag.Bool("wait", false, "wait completion of scan process")
+	follow := flag.Bool("follow", false, "refresh the status until the scan ends")
+	index := flag.String("index", "", "take candidate libjvm files from an index: locate, dpkg or auto")
+	quick := flag.Bool("quick", false, "only probe well-known JDK locations")
+	archives := flag.Bool("archives", false, "also look for JDKs inside .tar.gz, .tar.xz, .zip, .rpm and .deb files, not with -quick or -index; zstd compressed ones, like the rpms of RHEL 9 and the debs of Ubuntu 21.10 and later, are skipped")
+	dryrun := flag.Bool("dryrun", false, "only show which mounts would be scanned, not with -quick or -index")
+	resume := flag.Bool("resume", false, "continue an interrupted scan")
+	full := flag.Bool("full", false, "re-analyse all installations ignoring results of previous scans")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of parallel installation analysis workers")
+	iorate := flag.Float64("iorate", 0, "limit reading of analysed files to this many MB/s")
+	nice := flag.Int("nice", 0, "CPU scheduling priority (niceness) of the scan")
+	ionice := flag.String("ionice", "", "I/O scheduling of the scan: idle, be[:0-7] or rt[:0-7]")
+	maxdepth := flag.Int("maxdepth", 0, "do not descend more than this many levels below the scan roots")
+	maxfiles := flag.Int64("maxfiles", 0, "stop the scan after examining this many files")
+	timeout := flag.Duration("timeout", 0, "stop the scan after this time, e.g. 30m")
+	olderThan := flag.String("olderthan", "", "only report installations of a Java version older than this one, e.g. 11.0.20")
 	version := flag.Bool("version", false, "show version and exit")
 
 	flag.Usage = func() {
@@ -69,10 +168,12 @@ func InitConfig() *Config {
 		fmt.Println(name, "- Utility to find JVMs/JDKs and report their versions")
 		fmt.Println("Version:", VERSION)
 		fmt.Println()
-		fmt.Printf("Usage: %s [-json|-csv] [-skipfs=fstype[,fstype..]] [-nojvmrun] [-wait] [-root=<scanroot>] %s\n", name, CMD_START)
-		fmt.Printf("       %s [-json|-csv] [-wait] %s\n", name, CMD_STATUS)
-		fmt.Printf("       %s [-json|-csv] [-wait] %s\n", name, CMD_REPORT)
+		fmt.Printf("Usage: %s [-json|-csv] [-skipfs=fstype[,fstype..]] [-skipmount=path[,path..]] [-onefs] [-sysroot=<dir>] [-nojvmrun] [-crosscheck] [-detectors=name[,name..]] [-wait] [-workers=N] [-full] [-nice=N] [-ionice=class[:level]] [-iorate=MB/s] [-maxdepth=N] [-maxfiles=N] [-timeout=duration] [-root=<scanroot>].. [-exclude=<pattern>].. [-include=<pattern>].. [-dryrun] [-resume] [-quick] [-index=locate|dpkg|auto] [-archives] %s\n", name, CMD_START)
+		fmt.Printf("       %s [-json|-csv] [-wait|-follow] %s\n", name, CMD_STATUS)
+		fmt.Printf("       %s [-json|-csv] [-wait] [-olderthan=version] %s\n", name, CMD_REPORT)
+		fmt.Printf("       %s [-json|-csv] [-wait] %s\n", name, CMD_COVERAGE)
 		fmt.Printf("       %s [-json|-csv] %s\n", name, CMD_STOP)
+		fmt.Printf("       %s [-json|-csv] %s <image.tar>\n", name, CMD_SCAN_IMAGE)
 		fmt.Printf("       %s [-json|-csv] -version\n", name)
 		flag.PrintDefaults()
 	}
@@ -96,14 +197,19 @@ func InitConfig() *Config {
 		os.Exit(0)
 	}
 
-	if flag.NArg() != 1 {
+	nargs := 1
+	if CommandType(flag.Arg(0)) == CMD_SCAN_IMAGE {
+		nargs = 2
+	}
+	if flag.NArg() != nargs {
 		flag.Usage()
 		return nil
 	}
 
 	config.command = CommandType(flag.Arg(0))
+	config.imageFile = flag.Arg(1)
 
-	allowedChars := regexp.MustCompile(`^[a-z,]+$`).MatchString
+	allowedChars := regexp.MustCompile(`^[a-z0-9_.,]+$`).MatchString
 	if *skipfs != "" && !allowedChars(*skipfs) {
 		fmt.Println("Error: bad -skipfs parameter:", *skipfs)
 		os.Exit(1)
@@ -115,11 +221,165 @@ func InitConfig() *Config {
 		}
 	}
 
+	for _, p := range strings.Split(*skipmount, ",") {
+		if p != "" {
+			if !path.IsAbs(p) {
+				fmt.Println("Error: bad -skipmount parameter:", p)
+				os.Exit(1)
+			}
+			config.skipmount = append(config.skipmount, path.Clean(p))
+		}
+	}
+
 	config.nojvmrun = *nojvmrun
+	config.crosscheck = *crosscheck
+	if *detectors != "" {
+		var e error
+		if config.detectors, e = parseDetectors(*detectors); e != nil {
+			fmt.Println("Error: bad -detectors parameter:", e)
+			os.Exit(1)
+		}
+	}
 	config.json = *outjson
 	config.csv = *outcsv
-	config.root = *root
 	config.wait = *wait
+	config.follow = *follow
+	config.onefs = *onefs
+	config.workers = *workers
+	config.full = *full
+	config.dryrun = *dryrun
+	config.resume = *resume
+	config.quick = *quick
+	config.archives = *archives
+	config.index = IndexType(*index)
+
+	switch config.index {
+	case INDEX_NONE, INDEX_LOCATE, INDEX_DPKG, INDEX_AUTO:
+	default:
+		fmt.Println("Error: bad -index parameter:", *index)
+		os.Exit(1)
+	}
+
+	if *olderThan != "" {
+		v, e := ParseJavaVersion(*olderThan)
+		if e != nil {
+			fmt.Println("Error: bad -olderthan parameter:", *olderThan)
+			os.Exit(1)
+		}
+		config.olderThan = &v
+	}
+
+	// Only the walk comes across archives
+	if config.archives && (config.quick || config.index != INDEX_NONE) {
+		fmt.Println("Error: -archives cannot be used with -quick or -index")
+		os.Exit(1)
+	}
+	// Only the walk has mounts to show
+	if config.dryrun && (config.quick || config.index != INDEX_NONE) {
+		fmt.Println("Error: -dryrun cannot be used with -quick or -index")
+		os.Exit(1)
+	}
+
+	if config.workers < 1 {
+		fmt.Println("Error: bad -workers parameter:", *workers)
+		os.Exit(1)
+	}
+
+	config.iorate = *iorate
+	config.nice = *nice
+	config.maxdepth = *maxdepth
+	config.maxfiles = *maxfiles
+	config.timeout = *timeout
+	config.ionice = -1
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "nice" {
+			config.setNice = true
+		}
+	})
+
+	if config.iorate < 0 {
+		fmt.Println("Error: bad -iorate parameter:", *iorate)
+		os.Exit(1)
+	}
+	if config.setNice && (config.nice < -20 || config.nice > 19) {
+		fmt.Println("Error: bad -nice parameter:", *nice)
+		os.Exit(1)
+	}
+	if *ionice != "" {
+		prio, err := parseIONice(*ionice)
+		if err != nil {
+			fmt.Println("Error:", err.Error())
+			os.Exit(1)
+		}
+		config.ionice = prio
+	}
+	if config.maxdepth < 0 {
+		fmt.Println("Error: bad -maxdepth parameter:", *maxdepth)
+		os.Exit(1)
+	}
+	if config.maxfiles < 0 {
+		fmt.Println("Error: bad -maxfiles parameter:", *maxfiles)
+		os.Exit(1)
+	}
+	if config.timeout < 0 {
+		fmt.Println("Error: bad -timeout parameter:", *timeout)
+		os.Exit(1)
+	}
+
+	if *sysroot != "" {
+		abs, err := filepath.Abs(*sysroot)
+		if info, e := os.Stat(abs); err != nil || e != nil || !info.IsDir() {
+			fmt.Println("Error: bad -sysroot parameter:", *sysroot)
+			os.Exit(1)
+		}
+		if abs != "/" {
+			config.sysroot = abs
+			// The binaries may be built for another system
+			config.nojvmrun = true
+		}
+	}
+
+	if len(roots) == 0 {
+		roots = stringList{"/"}
+	}
+	for _, r := range roots {
+		abs, err := filepath.Abs(r)
+		if config.sysroot != "" {
+			// Roots are paths of the scanned system
+			abs = path.Join("/", r)
+		}
+		if err != nil {
+			fmt.Println("Error: bad -root parameter:", r)
+			os.Exit(1)
+		}
+		config.roots = append(config.roots, abs)
+	}
+	config.roots = removeNestedPaths(config.roots)
+
+	filter, err := NewPathFilter(exclude, include)
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		os.Exit(1)
+	}
+	config.exclude = exclude
+	config.include = include
+	config.filter = filter
+	config.args = config.effectiveArgs()
+
+	if config.sysroot != "" {
+		// The scan set is given inside the sysroot, while the scan walks
+		// the host
+		for i, r := range config.roots {
+			config.roots[i] = config.hostPath(r)
+			if real, e := config.evalSymlinks(config.roots[i]); e == nil {
+				config.roots[i] = real
+			}
+		}
+		for i, p := range config.skipmount {
+			config.skipmount[i] = config.hostPath(p)
+		}
+		filter.root = config.sysroot
+	}
 
 	u, err := user.Current()
 	checkError(err)
@@ -139,6 +399,105 @@ func InitConfig() *Config {
 	return &config
 }
 
+// Flags that decide what a scan visits
+var scanSetFlags = map[string]bool{
+	"root": true, "skipfs": true, "skipmount": true, "onefs": true, "exclude": true, "include": true, "quick": true, "index": true,
+	"maxdepth": true, "archives": true, "sysroot": true,
+}
+
+// scanSetArgs returns the arguments of args that decide what a scan visits
+func scanSetArgs(args []string) string {
+	var res []string
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if scanSetFlags[name] {
+			res = append(res, arg)
+		}
+	}
+	return strings.Join(res, " ")
+}
+
+// effectiveArgs returns the arguments of the command line with the complete
+// scan set spelled out, including the defaults, so that a scan can be
+// reproduced from its status
+func (c *Config) effectiveArgs() []string {
+	var args []string
+	if c.sysroot != "" {
+		args = append(args, "-sysroot="+c.sysroot)
+	}
+	for _, r := range c.roots {
+		args = append(args, "-root="+r)
+	}
+	args = append(args, "-skipfs="+strings.Join(c.skipfs, ","))
+	if len(c.skipmount) > 0 {
+		args = append(args, "-skipmount="+strings.Join(c.skipmount, ","))
+	}
+	if c.onefs {
+		args = append(args, "-onefs")
+	}
+	if c.quick {
+		args = append(args, "-quick")
+	}
+	if c.index != INDEX_NONE {
+		args = append(args, "-index="+string(c.index))
+	}
+	if c.archives {
+		args = append(args, "-archives")
+	}
+	for _, p := range c.exclude {
+		args = append(args, "-exclude="+p)
+	}
+	for _, p := range c.include {
+		args = append(args, "-include="+p)
+	}
+	if c.maxdepth > 0 {
+		args = append(args, fmt.Sprintf("-maxdepth=%d", c.maxdepth))
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if scanSetFlags[f.Name] {
+			return
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() && f.Value.String() == "true" {
+			args = append(args, "-"+f.Name)
+		} else {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+		}
+	})
+	return args
+}
+
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// removeNestedPaths drops duplicates and paths located below other paths
+// of the list
+func removeNestedPaths(paths []string) []string {
+	var res []string
+outer:
+	for i, p := range paths {
+		for j, other := range paths {
+			if i != j && isSubPath(p, other) && (p != other || j < i) {
+				continue outer
+			}
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
 func checkError(err error) {
 	if err != nil {
 		fmt.Println("Error: ", err.Error())
@@ -156,4 +515,3 @@ func getLibJVMFileName() string {
 		return "libjvm.so"
 	}
 }
-
@@ -0,0 +1,534 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Container runtimes whose storage is understood
+const (
+	RUNTIME_DOCKER     = "docker"
+	RUNTIME_CONTAINERD = "containerd"
+	RUNTIME_PODMAN     = "podman"
+)
+
+const (
+	dockerDaemonConfig   = "/etc/docker/daemon.json"
+	dockerDefaultRoot    = "/var/lib/docker"
+	containerdRoot       = "/var/lib/containerd"
+	podmanRootfulStorage = "/var/lib/containers/storage"
+	podmanRootlessDir    = ".local/share/containers/storage"
+)
+
+// Directories of containerd with one directory per running container
+var containerdTaskDirs = []string{
+	"/run/containerd/io.containerd.runtime.v2.task",
+	"/run/containerd/io.containerd.runtime.v1.linux",
+}
+
+// ImageRef names a container image
+type ImageRef struct {
+	Name   string `json:"name,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	ID     string `json:"id,omitempty"`
+}
+
+func (ref ImageRef) String() string {
+	s := ref.Name
+	if s == "" {
+		s = ref.ID
+	}
+	if ref.Tag != "" {
+		s += ":" + ref.Tag
+	}
+	if ref.Digest != "" {
+		s += "@" + ref.Digest
+	}
+	return s
+}
+
+// ContainerLayer is a directory holding the files of an image layer, or
+// of the writable layer of a container
+type ContainerLayer struct {
+	Runtime string
+	ID      string
+	Dir     string
+	// The container whose writable layer this is, if any
+	Container string
+	Images    []ImageRef
+	// Running containers that see the files of the layer
+	Running []string
+}
+
+// ContainerStorage maps the layer directories of the container runtimes
+// found on the host to the images and containers using them
+type ContainerStorage struct {
+	mounts *MountTable
+	layers map[string]*ContainerLayer // by directory
+	roots  []string
+}
+
+func LoadContainerStorage(mounts *MountTable) *ContainerStorage {
+	cs := &ContainerStorage{
+		mounts: mounts,
+		layers: make(map[string]*ContainerLayer),
+	}
+	cs.loadDocker(dockerDataRoot())
+	cs.loadContainerd(containerdRoot)
+	cs.loadPodman(podmanRootfulStorage)
+	for _, home := range homeDirectories("") {
+		cs.loadPodman(path.Join(home, podmanRootlessDir))
+	}
+	for _, layer := range cs.layers {
+		sort.Slice(layer.Images, func(i, j int) bool {
+			return layer.Images[i].String() < layer.Images[j].String()
+		})
+		sort.Strings(layer.Running)
+	}
+	return cs
+}
+
+// LayerOf returns the layer the given path belongs to, or nil
+func (cs *ContainerStorage) LayerOf(p string) *ContainerLayer {
+	for ; p != "/" && p != "."; p = path.Dir(p) {
+		if layer, ok := cs.layers[p]; ok {
+			return layer
+		}
+	}
+	return nil
+}
+
+// OwnsMount reports whether m is the root filesystem of a container, made
+// of layers that are scanned on their own
+func (cs *ContainerStorage) OwnsMount(m *Mount) bool {
+	if m.FSType != "overlay" {
+		return false
+	}
+	for _, root := range cs.roots {
+		if isSubPath(m.MountPoint, root) && m.MountPoint != root {
+			return true
+		}
+	}
+	return false
+}
+
+// Attribute fills in the images and containers of an installation found
+// in a container layer
+func (cs *ContainerStorage) Attribute(inst *JVMInstallation) {
+	inst.ContainerRuntime, inst.Layer, inst.Container = "", "", ""
+	inst.Images, inst.Containers = nil, nil
+	if layer := cs.LayerOf(inst.source()); layer != nil {
+		inst.ContainerRuntime = layer.Runtime
+		inst.Layer = layer.ID
+		inst.Container = layer.Container
+		inst.Images = layer.Images
+		inst.Containers = layer.Running
+	}
+}
+
+func (cs *ContainerStorage) addLayer(runtime string, id string, dir string) *ContainerLayer {
+	if layer, ok := cs.layers[dir]; ok {
+		return layer
+	}
+	layer := &ContainerLayer{Runtime: runtime, ID: id, Dir: dir}
+	cs.layers[dir] = layer
+	return layer
+}
+
+func (layer *ContainerLayer) addImages(refs []ImageRef) {
+	for _, ref := range refs {
+		if !layer.hasImage(ref) {
+			layer.Images = append(layer.Images, ref)
+		}
+	}
+}
+
+func (layer *ContainerLayer) hasImage(ref ImageRef) bool {
+	for _, other := range layer.Images {
+		if other == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func (layer *ContainerLayer) addRunning(id string) {
+	for _, other := range layer.Running {
+		if other == id {
+			return
+		}
+	}
+	layer.Running = append(layer.Running, id)
+}
+
+// chainIDs returns the identifiers of the layer stacks of an image with
+// the given layer diffs, as defined by the OCI image specification
+func chainIDs(diffIDs []string) []string {
+	var chain []string
+	for i, diffID := range diffIDs {
+		if i == 0 {
+			chain = append(chain, diffID)
+			continue
+		}
+		sum := sha256.Sum256([]byte(chain[i-1] + " " + diffID))
+		chain = append(chain, "sha256:"+hex.EncodeToString(sum[:]))
+	}
+	return chain
+}
+
+// parseImageRef splits a reference like registry/name:tag@digest
+func parseImageRef(ref string) ImageRef {
+	var res ImageRef
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		ref, res.Digest = ref[:i], ref[i+1:]
+	}
+	if i := strings.LastIndexByte(ref, ':'); i > strings.LastIndexByte(ref, '/') {
+		ref, res.Tag = ref[:i], ref[i+1:]
+	}
+	res.Name = ref
+	return res
+}
+
+// imageRefs turns the references of one image into one ImageRef per tag.
+// Digests are attached to the tags of the same repository.
+func imageRefs(id string, refs []string) []ImageRef {
+	var tagged, digested []ImageRef
+	for _, r := range refs {
+		ref := parseImageRef(r)
+		ref.ID = id
+		if ref.Digest != "" {
+			digested = append(digested, ref)
+		} else {
+			tagged = append(tagged, ref)
+		}
+	}
+	res := tagged
+	for _, d := range digested {
+		attached := false
+		for i := range res {
+			if res[i].Name == d.Name && res[i].Digest == "" {
+				res[i].Digest = d.Digest
+				attached = true
+			}
+		}
+		if !attached {
+			res = append(res, d)
+		}
+	}
+	if len(res) == 0 {
+		res = append(res, ImageRef{ID: id})
+	}
+	return res
+}
+
+func readJSONFile(filePath string, v interface{}) error {
+	data, e := ioutil.ReadFile(filePath)
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(data, v)
+}
+
+func readTrimmed(filePath string) string {
+	data, _ := ioutil.ReadFile(filePath)
+	return strings.TrimSpace(string(data))
+}
+
+func dockerDataRoot() string {
+	var config struct {
+		DataRoot string `json:"data-root"`
+		Graph    string `json:"graph"`
+	}
+	if readJSONFile(dockerDaemonConfig, &config) == nil {
+		if config.DataRoot != "" {
+			return config.DataRoot
+		}
+		if config.Graph != "" {
+			return config.Graph
+		}
+	}
+	return dockerDefaultRoot
+}
+
+// loadDocker reads the overlay2 storage of Docker. Image layers are kept
+// in image/overlay2/layerdb by chain ID, and point to their directory in
+// overlay2 through their cache-id.
+func (cs *ContainerStorage) loadDocker(root string) {
+	imageDir := path.Join(root, "image/overlay2")
+	if !dirExists(imageDir) {
+		return
+	}
+	cs.roots = append(cs.roots, root)
+
+	var repositories struct {
+		Repositories map[string]map[string]string
+	}
+	_ = readJSONFile(path.Join(imageDir, "repositories.json"), &repositories)
+	refsOf := make(map[string][]string)
+	for _, refs := range repositories.Repositories {
+		for ref, id := range refs {
+			refsOf[id] = append(refsOf[id], ref)
+		}
+	}
+
+	layerOf := func(chainID string) *ContainerLayer {
+		cacheID := readTrimmed(path.Join(imageDir, "layerdb/sha256", strings.TrimPrefix(chainID, "sha256:"), "cache-id"))
+		if cacheID == "" {
+			return nil
+		}
+		return cs.addLayer(RUNTIME_DOCKER, chainID, path.Join(root, "overlay2", cacheID, "diff"))
+	}
+
+	imageLayers := make(map[string][]*ContainerLayer)
+	entries, _ := ioutil.ReadDir(path.Join(imageDir, "imagedb/content/sha256"))
+	for _, entry := range entries {
+		var image struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if readJSONFile(path.Join(imageDir, "imagedb/content/sha256", entry.Name()), &image) != nil {
+			continue
+		}
+		id := "sha256:" + entry.Name()
+		refs := imageRefs(id, refsOf[id])
+		for _, chainID := range chainIDs(image.RootFS.DiffIDs) {
+			if layer := layerOf(chainID); layer != nil {
+				layer.addImages(refs)
+				imageLayers[id] = append(imageLayers[id], layer)
+			}
+		}
+	}
+
+	entries, _ = ioutil.ReadDir(path.Join(root, "containers"))
+	for _, entry := range entries {
+		var container struct {
+			ID    string
+			Image string
+			State struct {
+				Running bool
+			}
+		}
+		if readJSONFile(path.Join(root, "containers", entry.Name(), "config.v2.json"), &container) != nil {
+			continue
+		}
+		if container.State.Running {
+			for _, layer := range imageLayers[container.Image] {
+				layer.addRunning(container.ID)
+			}
+		}
+		mountID := readTrimmed(path.Join(imageDir, "layerdb/mounts", container.ID, "mount-id"))
+		if mountID == "" {
+			continue
+		}
+		layer := cs.addLayer(RUNTIME_DOCKER, container.ID, path.Join(root, "overlay2", mountID, "diff"))
+		layer.Container = container.ID
+		layer.addImages(imageRefs(container.Image, refsOf[container.Image]))
+		if container.State.Running {
+			layer.addRunning(container.ID)
+		}
+	}
+}
+
+// loadPodman reads the overlay storage of podman and other users of
+// containers/storage, which describes layers, images and containers in
+// JSON files
+func (cs *ContainerStorage) loadPodman(root string) {
+	if !dirExists(path.Join(root, "overlay-layers")) {
+		return
+	}
+	cs.roots = append(cs.roots, root)
+
+	var layers []struct {
+		ID     string `json:"id"`
+		Parent string `json:"parent"`
+	}
+	var images []struct {
+		ID     string   `json:"id"`
+		Digest string   `json:"digest"`
+		Names  []string `json:"names"`
+		Layer  string   `json:"layer"`
+	}
+	var containers []struct {
+		ID    string `json:"id"`
+		Image string `json:"image"`
+		Layer string `json:"layer"`
+	}
+	_ = readJSONFile(path.Join(root, "overlay-layers/layers.json"), &layers)
+	_ = readJSONFile(path.Join(root, "overlay-images/images.json"), &images)
+	_ = readJSONFile(path.Join(root, "overlay-containers/containers.json"), &containers)
+
+	parents := make(map[string]string)
+	for _, l := range layers {
+		parents[l.ID] = l.Parent
+	}
+	layerDir := func(id string) string {
+		return path.Join(root, "overlay", id, "diff")
+	}
+	// The layers of an image are its top layer and all its parents
+	imageLayers := func(top string) []*ContainerLayer {
+		var res []*ContainerLayer
+		for id := top; id != "" && len(res) <= len(parents); id = parents[id] {
+			res = append(res, cs.addLayer(RUNTIME_PODMAN, id, layerDir(id)))
+		}
+		return res
+	}
+
+	refsOf := make(map[string][]ImageRef)
+	layersOf := make(map[string][]*ContainerLayer)
+	for _, image := range images {
+		var refs []string
+		for _, name := range image.Names {
+			refs = append(refs, name)
+			if image.Digest != "" && !strings.Contains(name, "@") {
+				refs = append(refs, parseImageRef(name).Name+"@"+image.Digest)
+			}
+		}
+		refsOf[image.ID] = imageRefs(image.ID, refs)
+		layersOf[image.ID] = imageLayers(image.Layer)
+		for _, layer := range layersOf[image.ID] {
+			layer.addImages(refsOf[image.ID])
+		}
+	}
+
+	for _, container := range containers {
+		// A running container has its root filesystem mounted
+		running := cs.mounts.Lookup(path.Join(root, "overlay", container.Layer, "merged")) != nil
+		layer := cs.addLayer(RUNTIME_PODMAN, container.Layer, layerDir(container.Layer))
+		layer.Container = container.ID
+		layer.addImages(refsOf[container.Image])
+		if running {
+			layer.addRunning(container.ID)
+			for _, l := range layersOf[container.Image] {
+				l.addRunning(container.ID)
+			}
+		}
+	}
+}
+
+// loadContainerd reads the metadata of containerd and its overlayfs
+// snapshotter. Images point to their manifests in the content store,
+// whose configs list the layer diffs. The layers are snapshots named
+// by chain ID.
+func (cs *ContainerStorage) loadContainerd(root string) {
+	meta, e := OpenBoltDB(path.Join(root, "io.containerd.metadata.v1.bolt/meta.db"))
+	if e != nil {
+		return
+	}
+	snapshotterDir := path.Join(root, "io.containerd.snapshotter.v1.overlayfs")
+	snapshots, e := OpenBoltDB(path.Join(snapshotterDir, "metadata.db"))
+	if e != nil {
+		return
+	}
+	cs.roots = append(cs.roots, root)
+
+	blob := func(digest string) []byte {
+		data, _ := ioutil.ReadFile(path.Join(root, "io.containerd.content.v1.content/blobs", strings.Replace(digest, ":", "/", 1)))
+		return data
+	}
+	// snapshotDir finds the directory of a snapshot through its name in
+	// the snapshotter
+	snapshotDir := func(ns *BoltBucket, key string) string {
+		name := ns.Bucket("snapshots", "overlayfs", key).Get("name")
+		if name == nil {
+			return ""
+		}
+		id, n := binary.Uvarint(snapshots.Root().Bucket("v1", "snapshots", string(name)).Get("id"))
+		if n <= 0 {
+			return ""
+		}
+		return path.Join(snapshotterDir, "snapshots", strconv.FormatUint(id, 10), "fs")
+	}
+
+	meta.Root().Bucket("v1").ForEachBucket(func(namespace string, ns *BoltBucket) {
+		layersOf := make(map[string][]*ContainerLayer)
+		refsOf := make(map[string][]ImageRef)
+		ns.Bucket("images").ForEachBucket(func(name string, image *BoltBucket) {
+			digest := string(image.Bucket("target").Get("digest"))
+			if strings.HasPrefix(name, "sha256:") || digest == "" {
+				// Kubernetes refers to images by ID as well
+				return
+			}
+			ref := parseImageRef(name)
+			ref.Digest = digest
+			refsOf[name] = []ImageRef{ref}
+			for _, chainID := range chainIDs(containerdDiffIDs(blob, digest)) {
+				if dir := snapshotDir(ns, chainID); dir != "" {
+					layer := cs.addLayer(RUNTIME_CONTAINERD, chainID, dir)
+					layer.addImages(refsOf[name])
+					layersOf[name] = append(layersOf[name], layer)
+				}
+			}
+		})
+		ns.Bucket("containers").ForEachBucket(func(id string, container *BoltBucket) {
+			image := string(container.Get("image"))
+			running := false
+			for _, taskDir := range containerdTaskDirs {
+				running = running || dirExists(path.Join(taskDir, namespace, id))
+			}
+			if running {
+				for _, layer := range layersOf[image] {
+					layer.addRunning(id)
+				}
+			}
+			if dir := snapshotDir(ns, string(container.Get("snapshotKey"))); dir != "" {
+				layer := cs.addLayer(RUNTIME_CONTAINERD, id, dir)
+				layer.Container = id
+				layer.addImages(refsOf[image])
+				if running {
+					layer.addRunning(id)
+				}
+			}
+		})
+	})
+}
+
+// containerdDiffIDs returns the layer diffs of the image with the given
+// manifest or index, picking the manifest of this platform from an index
+func containerdDiffIDs(blob func(digest string) []byte, digest string) []string {
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if json.Unmarshal(blob(digest), &manifest) != nil {
+		return nil
+	}
+	if len(manifest.Manifests) > 0 {
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+				return containerdDiffIDs(blob, m.Digest)
+			}
+		}
+		return nil
+	}
+	var config struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if manifest.Config.Digest == "" || json.Unmarshal(blob(manifest.Config.Digest), &config) != nil {
+		return nil
+	}
+	return config.RootFS.DiffIDs
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	dockerImageApp  = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	dockerImageTool = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	dockerDiffBase  = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	dockerDiffApp   = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	dockerDiffTool  = "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+)
+
+func writeTestFile(t *testing.T, p string, content string) {
+	if e := os.MkdirAll(path.Dir(p), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(p, []byte(content), 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+// writeDockerRoot lays out the overlay2 storage of two images built on the
+// same base layer, app with two tags and tool with one. Containers c1 and
+// c3 run app, c2 runs tool, c4 is a stopped app with a writable layer.
+func writeDockerRoot(t *testing.T, root string) {
+	imageDir := path.Join(root, "image/overlay2")
+	writeTestFile(t, path.Join(imageDir, "repositories.json"), `{"Repositories": {
+		"app": {"app:1": "`+dockerImageApp+`", "app:latest": "`+dockerImageApp+`"},
+		"tool": {"tool:2": "`+dockerImageTool+`"}
+	}}`)
+	images := map[string][]string{
+		dockerImageApp:  {dockerDiffBase, dockerDiffApp},
+		dockerImageTool: {dockerDiffBase, dockerDiffTool},
+	}
+	for id, diffIDs := range images {
+		writeTestFile(t, path.Join(imageDir, "imagedb/content/sha256", strings.TrimPrefix(id, "sha256:")),
+			`{"rootfs": {"type": "layers", "diff_ids": ["`+strings.Join(diffIDs, `", "`)+`"]}}`)
+		for i, chainID := range chainIDs(diffIDs) {
+			cacheID := "base"
+			if i > 0 {
+				cacheID = strings.TrimPrefix(diffIDs[i], "sha256:")[:8]
+			}
+			writeTestFile(t, path.Join(imageDir, "layerdb/sha256", strings.TrimPrefix(chainID, "sha256:"), "cache-id"), cacheID)
+		}
+	}
+	containers := []struct {
+		id, image string
+		running   bool
+	}{
+		{"c1", dockerImageApp, true},
+		{"c2", dockerImageTool, true},
+		{"c3", dockerImageApp, true},
+		{"c4", dockerImageApp, false},
+	}
+	for _, c := range containers {
+		running := "false"
+		if c.running {
+			running = "true"
+		}
+		writeTestFile(t, path.Join(root, "containers", c.id, "config.v2.json"),
+			`{"ID": "`+c.id+`", "Image": "`+c.image+`", "State": {"Running": `+running+`}}`)
+	}
+	writeTestFile(t, path.Join(imageDir, "layerdb/mounts/c4/mount-id"), "c4mount\n")
+}
+
+func TestAttributeSharedLayer(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	writeDockerRoot(t, root)
+	cs := &ContainerStorage{layers: make(map[string]*ContainerLayer)}
+	cs.loadDocker(root)
+	// The tags of an image come in the order of a map
+	for _, layer := range cs.layers {
+		sort.Slice(layer.Images, func(i, j int) bool {
+			return layer.Images[i].String() < layer.Images[j].String()
+		})
+	}
+
+	app := []ImageRef{
+		{Name: "app", Tag: "1", ID: dockerImageApp},
+		{Name: "app", Tag: "latest", ID: dockerImageApp},
+	}
+	tool := []ImageRef{{Name: "tool", Tag: "2", ID: dockerImageTool}}
+	tests := []struct {
+		dir        string
+		layer      string
+		container  string
+		images     []ImageRef
+		containers []string
+	}{
+		{"overlay2/base/diff", dockerDiffBase, "", append(append([]ImageRef(nil), app...), tool...), []string{"c1", "c2", "c3"}},
+		{"overlay2/bbbbbbbb/diff", chainIDs([]string{dockerDiffBase, dockerDiffApp})[1], "", app, []string{"c1", "c3"}},
+		{"overlay2/cccccccc/diff", chainIDs([]string{dockerDiffBase, dockerDiffTool})[1], "", tool, []string{"c2"}},
+		{"overlay2/c4mount/diff", "c4", "c4", app, nil},
+	}
+	for _, test := range tests {
+		inst := &JVMInstallation{LibJVM: path.Join(root, test.dir, "usr/lib/jvm/zulu11/lib/server/libjvm.so")}
+		cs.Attribute(inst)
+		if inst.ContainerRuntime != RUNTIME_DOCKER || inst.Layer != test.layer || inst.Container != test.container {
+			t.Errorf("%s: got layer %q of %s, container %q", test.dir, inst.Layer, inst.ContainerRuntime, inst.Container)
+		}
+		if !reflect.DeepEqual(inst.Images, test.images) {
+			t.Errorf("%s: got images %v, want %v", test.dir, inst.Images, test.images)
+		}
+		if !reflect.DeepEqual(inst.Containers, test.containers) {
+			t.Errorf("%s: got containers %v, want %v", test.dir, inst.Containers, test.containers)
+		}
+	}
+
+	// Found outside of the layers, or attributed before
+	inst := &JVMInstallation{LibJVM: "/usr/lib/jvm/zulu11/lib/server/libjvm.so", Layer: "stale", Images: tool}
+	cs.Attribute(inst)
+	if inst.ContainerRuntime != "" || inst.Layer != "" || inst.Images != nil || inst.Containers != nil {
+		t.Errorf("got layer %q with images %v", inst.Layer, inst.Images)
+	}
+}
+
+// A layer reached again, as from a second image sharing it, keeps each of
+// its images and containers once
+func TestContainerLayerDedup(t *testing.T) {
+	cs := &ContainerStorage{layers: make(map[string]*ContainerLayer)}
+	refs := imageRefs(dockerImageApp, []string{"app:1", "app@sha256:0123"})
+	layer := cs.addLayer(RUNTIME_DOCKER, dockerDiffBase, "/var/lib/docker/overlay2/base/diff")
+	layer.addImages(refs)
+	layer.addRunning("c1")
+	again := cs.addLayer(RUNTIME_DOCKER, dockerDiffBase, "/var/lib/docker/overlay2/base/diff")
+	if again != layer {
+		t.Fatal("got a second layer for the same directory")
+	}
+	again.addImages(refs)
+	again.addRunning("c1")
+	want := []ImageRef{{Name: "app", Tag: "1", Digest: "sha256:0123", ID: dockerImageApp}}
+	if !reflect.DeepEqual(layer.Images, want) || !reflect.DeepEqual(layer.Running, []string{"c1"}) {
+		t.Errorf("got images %v, containers %v", layer.Images, layer.Running)
+	}
+}
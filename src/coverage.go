@@ -0,0 +1,306 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Reasons for not walking a mount
+const (
+	SkipFSType      = "fstype"
+	SkipMount       = "skipmount"
+	SkipExcluded    = "exclude"
+	SkipNotIncluded = "include"
+	SkipOtherFS     = "onefs"
+	SkipBind        = "bind mount"
+	SkipShadowed    = "mounted over"
+	SkipParent      = "parent skipped"
+	SkipMaxDepth    = "maxdepth"
+	SkipContainer   = "container"
+	SkipSnap        = "snap"
+	SkipNotInRoot   = "outside root"
+)
+
+// MountCoverage tells whether a mount was scanned
+type MountCoverage struct {
+	MountPoint     string `json:"mount_point"`
+	FSType         string `json:"fstype"`
+	Source         string `json:"source"`
+	Scanned        bool   `json:"scanned"`
+	Reason         string `json:"reason,omitempty"`
+	UnreadableDirs int    `json:"unreadable_dirs"`
+}
+
+// SkippedFile is an archive or a snap image that could not be looked into
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Coverage lists every mount under the scan roots along with the number of
+// directories that could not be read, and the files that were skipped
+type Coverage struct {
+	Mounts         []MountCoverage `json:"mounts"`
+	UnreadableDirs int             `json:"unreadable_dirs"`
+	SkippedFiles   []SkippedFile   `json:"skipped_files,omitempty"`
+}
+
+// rootOf returns the scan root p is located in, or the first scan root
+// located below p if there is none
+func (s *Scanner) rootOf(p string) string {
+	for _, r := range s.config.roots {
+		if isSubPath(p, r) {
+			return r
+		}
+	}
+	for _, r := range s.config.roots {
+		if isSubPath(r, p) {
+			return r
+		}
+	}
+	return ""
+}
+
+// depthOf returns the number of levels p is located below its scan root
+func (s *Scanner) depthOf(p string) int {
+	if root := s.rootOf(p); isSubPath(p, root) {
+		return len(splitPath(p)) - len(splitPath(root))
+	}
+	return 0
+}
+
+// isRootMount reports whether some scan root resides on m
+func (s *Scanner) isRootMount(m *Mount) bool {
+	for _, r := range s.config.roots {
+		if s.mounts.MountOf(r) == m {
+			return true
+		}
+	}
+	return false
+}
+
+// skipReason tells why the given mount is not walked, or returns an empty
+// string if it is
+func (s *Scanner) skipReason(m *Mount) string {
+	root := s.rootOf(m.MountPoint)
+	if root == "" || (!isSubPath(m.MountPoint, root) && !s.isRootMount(m)) {
+		return SkipNotInRoot
+	}
+	if s.mounts.Lookup(m.MountPoint) != m {
+		return SkipShadowed
+	}
+	for _, p := range s.config.skipmount {
+		if isSubPath(m.MountPoint, p) {
+			return SkipMount
+		}
+	}
+	if fsTypeMatches(m.FSType, s.config.skipfs) {
+		return SkipFSType
+	}
+	if s.containers.OwnsMount(m) {
+		return SkipContainer
+	}
+	if s.inScannedSnap(m) {
+		return SkipSnap
+	}
+	if isSubPath(m.MountPoint, root) {
+		if s.config.filter.Excluded(m.MountPoint) {
+			return SkipExcluded
+		}
+		if !s.config.filter.Included(m.MountPoint, true) {
+			return SkipNotIncluded
+		}
+	}
+	rootMount := s.mounts.MountOf(root)
+	if s.config.onefs && rootMount != nil && m.Dev != rootMount.Dev {
+		return SkipOtherFS
+	}
+	if !s.isRootMount(m) && s.boundToWalkedMount(m) {
+		return SkipBind
+	}
+	return ""
+}
+
+// walkedParts returns the directories of m's filesystem (relative to that
+// filesystem) that are walked through m
+func (s *Scanner) walkedParts(m *Mount) []string {
+	if !s.isRootMount(m) {
+		return []string{m.Root}
+	}
+	var parts []string
+	for _, r := range s.config.roots {
+		if s.mounts.MountOf(r) == m {
+			parts = append(parts, path.Join(m.Root, strings.TrimPrefix(r, m.MountPoint)))
+		}
+	}
+	return parts
+}
+
+// boundToWalkedMount reports whether m is a bind mount of a directory that
+// is already walked through another mount of the same filesystem
+func (s *Scanner) boundToWalkedMount(m *Mount) bool {
+	for _, other := range s.mounts.mounts {
+		if other == m || other.Dev != m.Dev || s.mounts.Lookup(other.MountPoint) != other {
+			continue
+		}
+		rootMount := s.isRootMount(other)
+		if !rootMount && s.rootOf(other.MountPoint) == "" {
+			continue
+		}
+		for _, covered := range s.walkedParts(other) {
+			if !isSubPath(m.Root, covered) || (m.Root == covered && other.ID > m.ID && !rootMount) {
+				continue
+			}
+			if rootMount || s.skipReason(other) == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// underSkippedMount reports whether some mount that m is nested into is
+// skipped. Everything mounted below a skipped mount is skipped as well.
+func (s *Scanner) underSkippedMount(m *Mount, root string) bool {
+	for p := path.Dir(m.MountPoint); isSubPath(p, root) && p != root; p = path.Dir(p) {
+		if parent := s.mounts.Lookup(p); parent != nil && s.skipReason(parent) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// planCoverage decides which of the mounts under the scan roots are walked
+func (s *Scanner) planCoverage() *Coverage {
+	c := &Coverage{}
+	for _, m := range s.mounts.mounts {
+		root := s.rootOf(m.MountPoint)
+		if root == "" || (!isSubPath(m.MountPoint, root) && !s.isRootMount(m)) {
+			continue
+		}
+		reason := s.skipReason(m)
+		if reason == "" && !s.isRootMount(m) && s.underSkippedMount(m, root) {
+			reason = SkipParent
+		}
+		if reason == "" && s.config.maxdepth > 0 && !s.isRootMount(m) && s.depthOf(m.MountPoint) >= s.config.maxdepth {
+			reason = SkipMaxDepth
+		}
+		c.Mounts = append(c.Mounts, MountCoverage{
+			MountPoint: m.MountPoint,
+			FSType:     m.FSType,
+			Source:     m.Source,
+			Scanned:    reason == "",
+			Reason:     reason,
+		})
+	}
+	return c
+}
+
+// walkRoots returns the directories that are walked in parallel: the scan
+// roots themselves and all the mounts below them that are not skipped.
+func (s *Scanner) walkRoots() []string {
+	var roots []string
+	for _, root := range s.config.roots {
+		if m := s.mounts.MountOf(root); m != nil && !s.coverage.scanned(m.MountPoint) {
+			continue
+		}
+		if s.config.filter.Admits(root, true) {
+			roots = append(roots, root)
+		}
+	}
+	for _, mc := range s.coverage.Mounts {
+		if mc.Scanned && !s.isRootMount(s.mounts.Lookup(mc.MountPoint)) {
+			roots = append(roots, mc.MountPoint)
+		}
+	}
+	return roots
+}
+
+func (c *Coverage) scanned(mountPoint string) bool {
+	for _, mc := range c.Mounts {
+		if mc.MountPoint == mountPoint {
+			return mc.Scanned
+		}
+	}
+	return false
+}
+
+// countUnreadable accounts a directory that could not be read to the mount
+// it belongs to
+func (c *Coverage) countUnreadable(mountPoint string) {
+	c.UnreadableDirs++
+	for i := range c.Mounts {
+		if c.Mounts[i].MountPoint == mountPoint {
+			c.Mounts[i].UnreadableDirs++
+			return
+		}
+	}
+}
+
+// skipFile records an archive or a snap image that is in a format that is
+// not supported
+func (c *Coverage) skipFile(p string, reason string) {
+	c.SkippedFiles = append(c.SkippedFiles, SkippedFile{p, reason})
+}
+
+func (c *Coverage) Report(config *Config) {
+	if config.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(c)
+	} else if config.csv {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"mount_point", "fstype", "source", "scanned", "reason", "unreadable_dirs"})
+		for _, mc := range c.Mounts {
+			w.Write([]string{
+				mc.MountPoint,
+				mc.FSType,
+				mc.Source,
+				strconv.FormatBool(mc.Scanned),
+				mc.Reason,
+				strconv.Itoa(mc.UnreadableDirs)})
+		}
+		w.Flush()
+	} else {
+		for _, mc := range c.Mounts {
+			fmt.Println("mount_point:", mc.MountPoint)
+			fmt.Println("fstype:", mc.FSType)
+			fmt.Println("source:", mc.Source)
+			fmt.Println("scanned:", mc.Scanned)
+			if mc.Reason != "" {
+				fmt.Println("reason:", mc.Reason)
+			}
+			fmt.Println("unreadable_dirs:", mc.UnreadableDirs)
+			fmt.Println()
+		}
+		fmt.Println("total_unreadable_dirs:", c.UnreadableDirs)
+		for _, sf := range c.SkippedFiles {
+			fmt.Println("skipped_file:", sf.Path, "("+sf.Reason+")")
+		}
+	}
+}
+
+// Summary returns a one line description of the coverage
+func (c *Coverage) Summary() string {
+	scanned := 0
+	for _, mc := range c.Mounts {
+		if mc.Scanned {
+			scanned++
+		}
+	}
+	summary := fmt.Sprintf("%d mounts scanned, %d skipped, %d unreadable directories",
+		scanned, len(c.Mounts)-scanned, c.UnreadableDirs)
+	if len(c.SkippedFiles) > 0 {
+		summary += fmt.Sprintf(", %d unsupported archives or images skipped", len(c.SkippedFiles))
+	}
+	return summary
+}
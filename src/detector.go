@@ -0,0 +1,209 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Names of the detectors of the versions of installations
+const (
+	SOURCE_EXEC    = "exec"
+	SOURCE_RELEASE = "release"
+	SOURCE_STRINGS = "strings"
+	SOURCE_RTJAR   = "rtjar"
+	SOURCE_JMOD    = "jmod"
+	SOURCE_JIMAGE  = "jimage"
+)
+
+// Detector reads the version of installations one way
+type Detector interface {
+	// Name is the name of the detector in -detectors and in the provenance
+	// of the fields it fills
+	Name() string
+	// Available tells whether the detector may be run on an installation
+	Available(inst *JVMInstallation, config *Config) bool
+	// Detect fills the VersionInfo of an installation, and returns false if
+	// it has not found the version
+	Detect(inst *JVMInstallation) bool
+}
+
+// detectorFunc is a Detector made of functions
+type detectorFunc struct {
+	name      string
+	available func(inst *JVMInstallation, config *Config) bool
+	detect    func(inst *JVMInstallation) bool
+}
+
+func (d detectorFunc) Name() string {
+	return d.name
+}
+
+func (d detectorFunc) Available(inst *JVMInstallation, config *Config) bool {
+	return d.available(inst, config)
+}
+
+func (d detectorFunc) Detect(inst *JVMInstallation) bool {
+	return d.detect(inst)
+}
+
+// detectorRegistry holds the known detectors, in the order they are run
+// unless -detectors tells another
+var detectorRegistry []Detector
+
+// RegisterDetector adds a detector, which runs after the ones known so far
+// unless -detectors tells otherwise, and can be selected by its name
+func RegisterDetector(d Detector) {
+	if lookupDetector(d.Name()) != nil {
+		panic("detector registered twice: " + d.Name())
+	}
+	detectorRegistry = append(detectorRegistry, d)
+}
+
+func init() {
+	RegisterDetector(detectorFunc{SOURCE_EXEC, func(inst *JVMInstallation, config *Config) bool {
+		return !config.nojvmrun && inst.JavaHome != ""
+	}, readVersionInfoFromOutput})
+	RegisterDetector(detectorFunc{SOURCE_RELEASE, func(inst *JVMInstallation, config *Config) bool {
+		return inst.Release != nil
+	}, readVersionInfoFromRelease})
+	RegisterDetector(detectorFunc{SOURCE_RTJAR, func(inst *JVMInstallation, config *Config) bool {
+		return inst.rt_jar != ""
+	}, readVersionInfoFromRtJar})
+	RegisterDetector(detectorFunc{SOURCE_JMOD, func(inst *JVMInstallation, config *Config) bool {
+		return inst.base_jmod != ""
+	}, readVersionInfoFromBaseJmod})
+	RegisterDetector(detectorFunc{SOURCE_JIMAGE, func(inst *JVMInstallation, config *Config) bool {
+		return inst.modules_image != ""
+	}, readVersionInfoFromModulesImage})
+	// The libjvm strings always give a version, which is a guess
+	RegisterDetector(detectorFunc{SOURCE_STRINGS, func(inst *JVMInstallation, config *Config) bool {
+		return true
+	}, readVersionInfoFromStrings})
+}
+
+func lookupDetector(name string) Detector {
+	return findDetector(detectorRegistry, name)
+}
+
+func findDetector(detectors []Detector, name string) Detector {
+	for _, d := range detectors {
+		if d.Name() == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// parseDetectors checks the comma separated list of -detectors
+func parseDetectors(list string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if lookupDetector(name) == nil {
+			return nil, errors.New("unknown detector: " + name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// detectors returns the detectors to run on an installation, in order
+func (inst *JVMInstallation) detectors(config *Config) []Detector {
+	var all []Detector
+	if len(config.detectors) == 0 {
+		all = detectorRegistry
+	} else {
+		for _, name := range config.detectors {
+			all = append(all, lookupDetector(name))
+		}
+	}
+	var res []Detector
+	for _, d := range all {
+		if d.Available(inst, config) {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// readVersion runs a detector on its own, leaving the installation as is
+func (inst *JVMInstallation) readVersion(d Detector) (JVMVersionInfo, bool) {
+	probe := *inst
+	probe.VersionInfo = JVMVersionInfo{}
+	ok := d.Detect(&probe)
+	return probe.VersionInfo, ok
+}
+
+// detectionResults keeps what each detector has found, so that none is
+// run twice
+type detectionResults map[string]*JVMVersionInfo
+
+// read runs a detector unless it has run already, and returns its version
+// or nil if it has found none
+func (results detectionResults) read(inst *JVMInstallation, d Detector) *JVMVersionInfo {
+	if info, ok := results[d.Name()]; ok {
+		return info
+	}
+	var found *JVMVersionInfo
+	if info, ok := inst.readVersion(d); ok {
+		found = &info
+	}
+	results[d.Name()] = found
+	return found
+}
+
+// detectVersion takes the version from the first detector that finds it
+func (inst *JVMInstallation) detectVersion(config *Config) {
+	inst.Confidence = CONFIDENCE_NONE
+	detectors := inst.detectors(config)
+	results := make(detectionResults)
+	for _, d := range detectors {
+		info := results.read(inst, d)
+		if info == nil {
+			continue
+		}
+		inst.takeVersionInfo(info, d.Name())
+		inst.Confidence = sourceConfidence[d.Name()]
+		if inst.Confidence == "" {
+			// Registered detectors are trusted no more than the strings
+			inst.Confidence = CONFIDENCE_LOW
+		}
+		// The release file has no VM names, and the version classes of older
+		// releases have no vendor, which the libjvm strings may give
+		switch d.Name() {
+		case SOURCE_RELEASE, SOURCE_RTJAR, SOURCE_JMOD, SOURCE_JIMAGE:
+			if strs := findDetector(detectors, SOURCE_STRINGS); strs != nil {
+				if info := results.read(inst, strs); info != nil {
+					inst.takeVersionInfo(info, SOURCE_STRINGS)
+				}
+			}
+		}
+		break
+	}
+
+	if config.crosscheck {
+		for _, d := range detectors {
+			results.read(inst, d)
+		}
+		inst.Disagreements = crossCheck(detectors, results)
+		if len(inst.Disagreements) > 0 {
+			switch inst.Confidence {
+			case CONFIDENCE_HIGH:
+				inst.Confidence = CONFIDENCE_MEDIUM
+			case CONFIDENCE_MEDIUM:
+				inst.Confidence = CONFIDENCE_LOW
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// A JDK 8 without a release file, whose rt.jar has no vendor
+func rtJarFixture(t *testing.T, dir string) *JVMInstallation {
+	return &JVMInstallation{
+		fsys:   hostFS{},
+		LibJVM: writeLibJVMStrings(t, dir, libjvmStringFixtures[0].strings),
+		rt_jar: "testdata/rt.jar",
+	}
+}
+
+func TestDetectVersionFillsRtJarFromStrings(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	inst := rtJarFixture(t, dir)
+	inst.detectVersion(&Config{nojvmrun: true})
+	if inst.VersionInfo.Version != "1.8.0_292" || inst.Provenance["java_version"] != SOURCE_RTJAR {
+		t.Errorf("got version %q from %q", inst.VersionInfo.Version, inst.Provenance["java_version"])
+	}
+	if inst.VersionInfo.VMVendor != "Azul Systems, Inc." || inst.Provenance["java_vm_vendor"] != SOURCE_STRINGS {
+		t.Errorf("got vendor %q from %q", inst.VersionInfo.VMVendor, inst.Provenance["java_vm_vendor"])
+	}
+	if inst.Confidence != CONFIDENCE_MEDIUM {
+		t.Errorf("got confidence %s", inst.Confidence)
+	}
+}
+
+func TestDetectVersionWithoutStrings(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	inst := rtJarFixture(t, dir)
+	inst.detectVersion(&Config{nojvmrun: true, detectors: []string{SOURCE_RTJAR}})
+	if inst.VersionInfo.Version != "1.8.0_292" {
+		t.Errorf("got version %q", inst.VersionInfo.Version)
+	}
+	if inst.VersionInfo.VMVendor != "" {
+		t.Errorf("got vendor %q, with strings left out", inst.VersionInfo.VMVendor)
+	}
+}
+
+func TestRegisterDetector(t *testing.T) {
+	defer func(registry []Detector) { detectorRegistry = registry }(detectorRegistry)
+	RegisterDetector(detectorFunc{"fixed", func(inst *JVMInstallation, config *Config) bool {
+		return true
+	}, func(inst *JVMInstallation) bool {
+		inst.VersionInfo.Version = "17.0.4"
+		return true
+	}})
+
+	detectors, e := parseDetectors("fixed,strings")
+	if e != nil {
+		t.Fatal(e)
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	inst := rtJarFixture(t, dir)
+	inst.detectVersion(&Config{nojvmrun: true, detectors: detectors})
+	if inst.VersionInfo.Version != "17.0.4" || inst.Provenance["java_version"] != "fixed" {
+		t.Errorf("got version %q from %q", inst.VersionInfo.Version, inst.Provenance["java_version"])
+	}
+	if inst.Confidence != CONFIDENCE_LOW {
+		t.Errorf("got confidence %s", inst.Confidence)
+	}
+
+	// Unless it is listed, it runs after the others
+	inst = rtJarFixture(t, dir)
+	inst.detectVersion(&Config{nojvmrun: true})
+	if inst.Provenance["java_version"] != SOURCE_RTJAR {
+		t.Errorf("got version %q from %q", inst.VersionInfo.Version, inst.Provenance["java_version"])
+	}
+}
+
+func TestParseDetectorsUnknown(t *testing.T) {
+	if _, e := parseDetectors("release,fixed"); e == nil {
+		t.Error("got no error for an unregistered detector")
+	}
+}
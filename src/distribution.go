@@ -0,0 +1,183 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+)
+
+// Canonical names of the distributions of Java
+const (
+	DIST_TEMURIN        = "Temurin"
+	DIST_ZULU           = "Zulu"
+	DIST_ZING           = "Zing"
+	DIST_PRIME          = "Prime"
+	DIST_CORRETTO       = "Corretto"
+	DIST_LIBERICA       = "Liberica"
+	DIST_MICROSOFT      = "Microsoft"
+	DIST_REDHAT         = "Red Hat"
+	DIST_ORACLE_JDK     = "Oracle JDK"
+	DIST_ORACLE_OPENJDK = "Oracle OpenJDK"
+	DIST_SEMERU         = "Semeru"
+	DIST_IBM_SDK        = "IBM SDK"
+	DIST_SAPMACHINE     = "SapMachine"
+	DIST_DRAGONWELL     = "Dragonwell"
+	DIST_GRAALVM_CE     = "GraalVM CE"
+	DIST_GRAALVM_EE     = "GraalVM EE"
+	DIST_JBR            = "JetBrains Runtime"
+)
+
+// distributionHint tells the distribution of the strings holding a text,
+// compared ignoring case
+type distributionHint struct {
+	text         string
+	distribution string
+}
+
+// Hints for the vendor version, or IMPLEMENTOR_VERSION of the release file,
+// which most vendors brand their builds with
+var vendorVersionHints = []distributionHint{
+	{"Temurin", DIST_TEMURIN},
+	{"Zulu", DIST_ZULU},
+	{"Zing", DIST_ZING},
+	{"Prime", DIST_PRIME},
+	{"Corretto", DIST_CORRETTO},
+	{"Liberica", DIST_LIBERICA},
+	{"BellSoft", DIST_LIBERICA},
+	{"Microsoft", DIST_MICROSOFT},
+	{"Red_Hat", DIST_REDHAT},
+	{"Red Hat", DIST_REDHAT},
+	{"Semeru", DIST_SEMERU},
+	{"SapMachine", DIST_SAPMACHINE},
+	{"Dragonwell", DIST_DRAGONWELL},
+	{"GraalVM CE", DIST_GRAALVM_CE},
+	{"GraalVM EE", DIST_GRAALVM_EE},
+	{"Oracle GraalVM", DIST_GRAALVM_EE},
+	{"JBR", DIST_JBR},
+}
+
+// Hints for the vendor, or IMPLEMENTOR of the release file. Azul, IBM and
+// Oracle make several distributions, which the names of the runtime and
+// the VM tell apart.
+var vendorHints = []distributionHint{
+	{"Eclipse Adoptium", DIST_TEMURIN},
+	{"Eclipse Foundation", DIST_TEMURIN},
+	{"AdoptOpenJDK", DIST_TEMURIN},
+	{"Azul Systems", DIST_ZULU},
+	{"Amazon.com", DIST_CORRETTO},
+	{"BellSoft", DIST_LIBERICA},
+	{"Microsoft", DIST_MICROSOFT},
+	{"Red Hat", DIST_REDHAT},
+	{"IBM Corporation", DIST_IBM_SDK},
+	{"International Business Machines", DIST_IBM_SDK},
+	{"SAP SE", DIST_SAPMACHINE},
+	{"Alibaba", DIST_DRAGONWELL},
+	{"GraalVM Community", DIST_GRAALVM_CE},
+	{"JetBrains", DIST_JBR},
+	{"Oracle Corporation", DIST_ORACLE_JDK},
+}
+
+// Hints for the directories of the Java home, for the builds that tell
+// nothing else
+var pathHints = []distributionHint{
+	{"temurin", DIST_TEMURIN},
+	{"adoptium", DIST_TEMURIN},
+	{"adoptopenjdk", DIST_TEMURIN},
+	{"zulu", DIST_ZULU},
+	{"zing", DIST_ZING},
+	{"corretto", DIST_CORRETTO},
+	{"liberica", DIST_LIBERICA},
+	{"msopenjdk", DIST_MICROSOFT},
+	{"microsoft", DIST_MICROSOFT},
+	{"semeru", DIST_SEMERU},
+	{"openj9", DIST_SEMERU},
+	{"sapmachine", DIST_SAPMACHINE},
+	{"dragonwell", DIST_DRAGONWELL},
+	{"graalvm-ce", DIST_GRAALVM_CE},
+	{"graalvm-community", DIST_GRAALVM_CE},
+	{"graalvm-ee", DIST_GRAALVM_EE},
+	{"graalvm-jdk", DIST_GRAALVM_EE},
+	{"jbr", DIST_JBR},
+	{"oracle", DIST_ORACLE_JDK},
+}
+
+func matchHints(hints []distributionHint, s string) string {
+	s = strings.ToLower(s)
+	for _, hint := range hints {
+		if strings.Contains(s, strings.ToLower(hint.text)) {
+			return hint.distribution
+		}
+	}
+	return ""
+}
+
+// identifyDistribution returns the canonical name of the distribution of
+// an installation, or an empty string if it cannot be told
+func identifyDistribution(inst *JVMInstallation) string {
+	info := &inst.VersionInfo
+	var vendorVersions, vendors []string
+	if inst.Release != nil {
+		vendorVersions = append(vendorVersions, inst.Release.ImplementorVersion)
+		vendors = append(vendors, inst.Release.Implementor)
+	}
+	vendorVersions = append(vendorVersions, info.VendorVersion, info.ProductVersion)
+	vendors = append(vendors, info.RuntimeVendor, info.VMVendor)
+	names := info.RuntimeName + " " + info.VMName
+
+	for _, vendorVersion := range vendorVersions {
+		if d := matchHints(vendorVersionHints, vendorVersion); d != "" {
+			return d
+		}
+	}
+	for _, vendor := range vendors {
+		switch d := matchHints(vendorHints, vendor); d {
+		case "":
+			continue
+		case DIST_ZULU:
+			if strings.Contains(names, "Zing") {
+				return DIST_ZING
+			}
+			return DIST_ZULU
+		case DIST_TEMURIN:
+			// AdoptOpenJDK also built OpenJ9, which is now Semeru
+			if strings.Contains(names, "OpenJ9") {
+				return DIST_SEMERU
+			}
+			return DIST_TEMURIN
+		case DIST_IBM_SDK:
+			// Semeru is IBM's build of OpenJ9, the IBM SDK has the J9 VM
+			if strings.Contains(names, "OpenJ9") || strings.Contains(names, "Semeru") {
+				return DIST_SEMERU
+			}
+			return DIST_IBM_SDK
+		case DIST_ORACLE_JDK:
+			switch {
+			case strings.Contains(names, "GraalVM"):
+				return DIST_GRAALVM_EE
+			case strings.Contains(names, "(TM)"):
+				return DIST_ORACLE_JDK
+			case strings.Contains(names, "OpenJDK"):
+				return DIST_ORACLE_OPENJDK
+			}
+			// The release files of both are the same
+		default:
+			return d
+		}
+	}
+	switch {
+	case strings.Contains(names, "HotSpot(TM)"), strings.Contains(names, "Java(TM)"):
+		return DIST_ORACLE_JDK
+	case strings.Contains(names, "Zing"):
+		return DIST_ZING
+	case strings.Contains(names, "OpenJ9"):
+		return DIST_SEMERU
+	}
+	for _, dir := range splitPath(inst.JavaHome) {
+		if d := matchHints(pathHints, dir); d != "" {
+			return d
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestIdentifyDistributionIBM(t *testing.T) {
+	tests := []struct {
+		name string
+		info JVMVersionInfo
+		want string
+	}{
+		{
+			name: "ibm sdk 8",
+			info: JVMVersionInfo{
+				RuntimeName:   "Java(TM) SE Runtime Environment",
+				RuntimeVendor: "IBM Corporation",
+				VMName:        "IBM J9 VM",
+				VMVendor:      "IBM Corporation",
+			},
+			want: DIST_IBM_SDK,
+		},
+		{
+			name: "ibm sdk 8 older",
+			info: JVMVersionInfo{
+				RuntimeName:   "Java(TM) SE Runtime Environment",
+				RuntimeVendor: "International Business Machines Corporation",
+				VMName:        "IBM J9 VM",
+			},
+			want: DIST_IBM_SDK,
+		},
+		{
+			name: "semeru 17",
+			info: JVMVersionInfo{
+				RuntimeName:   "IBM Semeru Runtime Open Edition",
+				RuntimeVendor: "IBM Corporation",
+				VMName:        "Eclipse OpenJ9 VM",
+				VMVendor:      "Eclipse OpenJ9",
+			},
+			want: DIST_SEMERU,
+		},
+		{
+			name: "semeru 11 vm",
+			info: JVMVersionInfo{
+				RuntimeVendor: "IBM Corporation",
+				VMName:        "Eclipse OpenJ9 VM",
+			},
+			want: DIST_SEMERU,
+		},
+	}
+	for _, test := range tests {
+		inst := &JVMInstallation{VersionInfo: test.info}
+		if got := identifyDistribution(inst); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// FileSystem is what installations are analysed in: the host, a system
+// mounted below a directory of the host, or the files of an image
+type FileSystem interface {
+	Open(name string) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	// ReadDir returns the entries of a directory sorted by name
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// File is an open file of a FileSystem
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// hostFS is the file system of the host
+type hostFS struct{}
+
+func (hostFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (hostFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (hostFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (hostFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// rootFS is the file system of a disk image or chroot mounted at a
+// directory of the host. Symbolic links, absolute ones included, are
+// resolved inside that directory as if it were the root.
+type rootFS struct {
+	root string
+}
+
+// sysrootFS returns the file system mounted at sysroot, or the one of the
+// host if sysroot is empty
+func sysrootFS(sysroot string) FileSystem {
+	if sysroot == "" {
+		return hostFS{}
+	}
+	return rootFS{sysroot}
+}
+
+// resolve returns name with its symbolic links resolved, but the last one
+// if follow is not set
+func (fsys rootFS) resolve(name string, follow bool) (string, error) {
+	parts := splitPath(name)
+	var resolved []string
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+		p := path.Join(fsys.root, strings.Join(resolved, "/"), part)
+		info, e := os.Lstat(p)
+		if e != nil {
+			return "", underlyingError(e)
+		}
+		if info.Mode()&os.ModeSymlink != 0 && (follow || len(parts) > 0) {
+			if links++; links > maxSymlinks {
+				return "", syscall.ELOOP
+			}
+			target, e := os.Readlink(p)
+			if e != nil {
+				return "", underlyingError(e)
+			}
+			if path.IsAbs(target) {
+				resolved = nil
+			}
+			parts = append(splitPath(target), parts...)
+			continue
+		}
+		resolved = append(resolved, part)
+	}
+	return "/" + strings.Join(resolved, "/"), nil
+}
+
+// hostPath returns where name is found on the host
+func (fsys rootFS) hostPath(op string, name string, follow bool) (string, error) {
+	resolved, e := fsys.resolve(name, follow)
+	if e != nil {
+		return "", &os.PathError{Op: op, Path: name, Err: e}
+	}
+	return path.Join(fsys.root, resolved), nil
+}
+
+func (fsys rootFS) Open(name string) (File, error) {
+	p, e := fsys.hostPath("open", name, true)
+	if e != nil {
+		return nil, e
+	}
+	return os.Open(p)
+}
+
+func (fsys rootFS) Stat(name string) (os.FileInfo, error) {
+	p, e := fsys.hostPath("stat", name, true)
+	if e != nil {
+		return nil, e
+	}
+	return os.Stat(p)
+}
+
+func (fsys rootFS) Lstat(name string) (os.FileInfo, error) {
+	p, e := fsys.hostPath("lstat", name, false)
+	if e != nil {
+		return nil, e
+	}
+	return os.Lstat(p)
+}
+
+func (fsys rootFS) ReadDir(name string) ([]os.FileInfo, error) {
+	p, e := fsys.hostPath("readdir", name, true)
+	if e != nil {
+		return nil, e
+	}
+	return ioutil.ReadDir(p)
+}
+
+// underlyingError strips the path off the errors of the os package
+func underlyingError(e error) error {
+	if pe, ok := e.(*os.PathError); ok {
+		return pe.Err
+	}
+	if le, ok := e.(*os.LinkError); ok {
+		return le.Err
+	}
+	return e
+}
+
+// walkFileSystem walks the tree rooted at root like filepath.Walk does
+func walkFileSystem(fsys FileSystem, root string, fn filepath.WalkFunc) error {
+	info, e := fsys.Lstat(root)
+	if e != nil {
+		e = fn(root, nil, e)
+	} else {
+		e = walkFileSystemTree(fsys, root, info, fn)
+	}
+	if e == filepath.SkipDir {
+		return nil
+	}
+	return e
+}
+
+func walkFileSystemTree(fsys FileSystem, p string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(p, info, nil)
+	}
+	entries, e := fsys.ReadDir(p)
+	e1 := fn(p, info, e)
+	if e != nil || e1 != nil {
+		return e1
+	}
+	for _, entry := range entries {
+		e = walkFileSystemTree(fsys, path.Join(p, entry.Name()), entry, fn)
+		if e != nil && (!entry.IsDir() || e != filepath.SkipDir) {
+			return e
+		}
+	}
+	return nil
+}
+
+// findInstallations analyses the installations of every libjvm of fsys
+// having one of the given names
+func findInstallations(fsys FileSystem, config *Config, libjvmNames ...string) []*JVMInstallation {
+	var libjvms []string
+	_ = walkFileSystem(fsys, "/", func(p string, info os.FileInfo, e error) error {
+		if e == nil && info.Mode().IsRegular() {
+			for _, name := range libjvmNames {
+				if info.Name() == name {
+					libjvms = append(libjvms, p)
+				}
+			}
+		}
+		return nil
+	})
+	var res []*JVMInstallation
+	for _, libjvm := range libjvms {
+		res = append(res, InitJVMInstallation(fsys, libjvm, config))
+	}
+	return res
+}
@@ -0,0 +1,356 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// Annotation of OCI layouts naming the images they hold
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// Media types of OCI and Docker image indexes
+var imageIndexMediaTypes = map[string]bool{
+	"application/vnd.oci.image.index.v1+json":                   true,
+	"application/vnd.docker.distribution.manifest.list.v2+json": true,
+}
+
+// Images are always Linux ones, whatever the scanning host
+const imageLibJVMFileName = "libjvm.so"
+
+// ImageArchive is a tarball made by docker save, or holding an OCI image
+// layout. It is read in place: nothing gets extracted.
+type ImageArchive struct {
+	file    *os.File
+	entries map[string]*io.SectionReader // by entry name
+	links   map[string]string            // symbolic link entries
+}
+
+// ArchivedImage is an image of an ImageArchive
+type ArchivedImage struct {
+	Refs []ImageRef
+	// Entries of the layer blobs in the archive, lowest first
+	layers  []string
+	diffIDs []string
+}
+
+func OpenImageArchive(filePath string) (*ImageArchive, error) {
+	f, e := os.Open(filePath)
+	if e != nil {
+		return nil, e
+	}
+	a := &ImageArchive{
+		file:    f,
+		entries: make(map[string]*io.SectionReader),
+		links:   make(map[string]string),
+	}
+
+	// Note where the contents of the entries are, so that the blobs can
+	// be read later on without going through the whole archive again.
+	// The tar reader reads no further than the header of an entry.
+	tr := tar.NewReader(f)
+	for {
+		hdr, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			closeFile(f)
+			return nil, fmt.Errorf("%s: %s", filePath, e.Error())
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			offset, e := f.Seek(0, io.SeekCurrent)
+			if e != nil {
+				closeFile(f)
+				return nil, e
+			}
+			a.entries[path.Clean(hdr.Name)] = io.NewSectionReader(f, offset, hdr.Size)
+		} else if hdr.Typeflag == tar.TypeSymlink {
+			// docker save stores a layer once and links to it
+			a.links[path.Clean(hdr.Name)] = path.Join(path.Dir(hdr.Name), hdr.Linkname)
+		}
+	}
+	return a, nil
+}
+
+func (a *ImageArchive) Close() {
+	closeFile(a.file)
+}
+
+// entry returns the contents of an entry, following symbolic links
+func (a *ImageArchive) entry(name string) (*io.SectionReader, error) {
+	name = path.Clean(name)
+	for i := 0; i < maxSymlinks; i++ {
+		if entry, ok := a.entries[name]; ok {
+			return io.NewSectionReader(entry, 0, entry.Size()), nil
+		}
+		target, ok := a.links[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	return nil, fmt.Errorf("%s not found in the image archive", name)
+}
+
+func (a *ImageArchive) readEntry(name string) ([]byte, error) {
+	entry, e := a.entry(name)
+	if e != nil {
+		return nil, e
+	}
+	return ioutil.ReadAll(entry)
+}
+
+func (a *ImageArchive) readJSONEntry(name string, v interface{}) error {
+	data, e := a.readEntry(name)
+	if e != nil {
+		return e
+	}
+	if e = json.Unmarshal(data, v); e != nil {
+		return fmt.Errorf("%s: %s", name, e.Error())
+	}
+	return nil
+}
+
+// blobEntry returns the name of the entry of an OCI blob
+func blobEntry(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// Images returns the images of the archive. The manifest.json of docker
+// save is preferred to the OCI index, as it holds the tags of the images.
+func (a *ImageArchive) Images() ([]*ArchivedImage, error) {
+	if _, ok := a.entries["manifest.json"]; ok {
+		return a.dockerImages()
+	}
+	if _, ok := a.entries["index.json"]; ok {
+		return a.ociImages()
+	}
+	return nil, errors.New("neither a docker save archive nor an OCI image layout")
+}
+
+func (a *ImageArchive) dockerImages() ([]*ArchivedImage, error) {
+	var manifest []struct {
+		Config   string   `json:"Config"`
+		RepoTags []string `json:"RepoTags"`
+		Layers   []string `json:"Layers"`
+	}
+	if e := a.readJSONEntry("manifest.json", &manifest); e != nil {
+		return nil, e
+	}
+	var images []*ArchivedImage
+	for _, m := range manifest {
+		config, e := a.readEntry(m.Config)
+		if e != nil {
+			return nil, e
+		}
+		sum := sha256.Sum256(config)
+		image := &ArchivedImage{
+			Refs:    imageRefs("sha256:"+hex.EncodeToString(sum[:]), m.RepoTags),
+			layers:  m.Layers,
+			diffIDs: configDiffIDs(config),
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (a *ImageArchive) ociImages() ([]*ArchivedImage, error) {
+	var index ociManifest
+	if e := a.readJSONEntry("index.json", &index); e != nil {
+		return nil, e
+	}
+	var images []*ArchivedImage
+	for _, m := range index.Manifests {
+		image, e := a.ociImage(m.MediaType, m.Digest, 0)
+		if e != nil {
+			return nil, e
+		}
+		if image == nil {
+			continue
+		}
+		var refs []string
+		if name := m.Annotations[ociRefNameAnnotation]; name != "" {
+			refs = append(refs, name)
+		}
+		image.Refs = imageRefs(image.Refs[0].ID, refs)
+		for i := range image.Refs {
+			if image.Refs[i].Digest == "" {
+				image.Refs[i].Digest = m.Digest
+			}
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// ociManifest is either an OCI image manifest or an OCI image index
+type ociManifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+	Manifests []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Platform  struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"manifests"`
+}
+
+// ociImage reads the image of a manifest, picking the one of this platform
+// from an index, or else the first Linux one
+func (a *ImageArchive) ociImage(mediaType string, digest string, depth int) (*ArchivedImage, error) {
+	var m ociManifest
+	if e := a.readJSONEntry(blobEntry(digest), &m); e != nil {
+		return nil, e
+	}
+	if imageIndexMediaTypes[mediaType] || len(m.Manifests) > 0 {
+		if depth > 8 {
+			return nil, errors.New("image indexes nested too deep")
+		}
+		pick := -1
+		for i, child := range m.Manifests {
+			if child.Platform.OS == "linux" && (pick < 0 || child.Platform.Architecture == runtime.GOARCH) {
+				pick = i
+				if child.Platform.Architecture == runtime.GOARCH {
+					break
+				}
+			}
+		}
+		if pick < 0 {
+			return nil, nil
+		}
+		return a.ociImage(m.Manifests[pick].MediaType, m.Manifests[pick].Digest, depth+1)
+	}
+	config, e := a.readEntry(blobEntry(m.Config.Digest))
+	if e != nil {
+		return nil, e
+	}
+	image := &ArchivedImage{
+		Refs:    []ImageRef{{ID: m.Config.Digest}},
+		diffIDs: configDiffIDs(config),
+	}
+	for _, layer := range m.Layers {
+		image.layers = append(image.layers, blobEntry(layer.Digest))
+	}
+	return image, nil
+}
+
+// configDiffIDs returns the layer diffs listed by an image config
+func configDiffIDs(config []byte) []string {
+	var c struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	_ = json.Unmarshal(config, &c)
+	return c.RootFS.DiffIDs
+}
+
+// layerID names the layer with the given index after its diff, or after
+// its blob if the config does not tell
+func (image *ArchivedImage) layerID(i int) string {
+	if len(image.diffIDs) == len(image.layers) {
+		return image.diffIDs[i]
+	}
+	return path.Base(image.layers[i])
+}
+
+// openLayer returns the entries of the tar archive of a layer
+func (a *ImageArchive) openLayer(name string) (archiveEntries, error) {
+	entry, e := a.entry(name)
+	if e != nil {
+		return nil, e
+	}
+	r, e := decompress(entry)
+	if e != nil {
+		return nil, fmt.Errorf("layer %s: %s", name, e.Error())
+	}
+	return tar.NewReader(r), nil
+}
+
+// FileSystem applies the layers of the image on top of each other. Only
+// the tree is held in memory; the contents of a file are read from its
+// layer when it gets opened.
+func (a *ImageArchive) FileSystem(image *ArchivedImage) (*memFS, error) {
+	fsys := newMemFS(func(n *memNode) ([]byte, error) {
+		tr, e := a.openLayer(image.layers[n.layer])
+		if e != nil {
+			return nil, e
+		}
+		for {
+			hdr, e := tr.Next()
+			if e == io.EOF {
+				return nil, os.ErrNotExist
+			}
+			if e != nil {
+				return nil, e
+			}
+			if path.Clean("/"+hdr.Name) == n.entry {
+				return ioutil.ReadAll(throttled(tr))
+			}
+		}
+	})
+	for i, layer := range image.layers {
+		tr, e := a.openLayer(layer)
+		if e != nil {
+			return nil, e
+		}
+		if e = fsys.applyLayer(tr, i); e != nil {
+			return nil, fmt.Errorf("layer %s: %s", layer, e.Error())
+		}
+	}
+	return fsys, nil
+}
+
+// ScanImageArchive finds the installations of every image of the archive
+// without running anything
+func ScanImageArchive(filePath string, config *Config) ([]*JVMInstallation, error) {
+	a, e := OpenImageArchive(filePath)
+	if e != nil {
+		return nil, e
+	}
+	defer a.Close()
+	images, e := a.Images()
+	if e != nil {
+		return nil, e
+	}
+
+	noRun := *config
+	noRun.nojvmrun = true
+	var res []*JVMInstallation
+	for _, image := range images {
+		fsys, e := a.FileSystem(image)
+		if e != nil {
+			return nil, e
+		}
+		for _, inst := range findInstallations(fsys, &noRun, imageLibJVMFileName) {
+			inst.ImageFile = filePath
+			inst.Images = image.Refs
+			if n, e := fsys.lookup(inst.LibJVM, false); e == nil {
+				inst.Layer = image.layerID(n.layer)
+			}
+			res = append(res, inst)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,350 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type IndexType string
+
+const (
+	INDEX_NONE   IndexType = ""
+	INDEX_LOCATE IndexType = "locate"
+	INDEX_DPKG   IndexType = "dpkg"
+	INDEX_AUTO   IndexType = "auto"
+)
+
+// Locate databases in the order they are looked for
+var locateDBPaths = []string{
+	"/var/lib/mlocate/mlocate.db",
+	"/var/lib/plocate/plocate.db",
+	"/var/cache/locate/locatedb",
+	"/var/lib/locate/locatedb",
+}
+
+const dpkgInfoDir = "/var/lib/dpkg/info"
+
+// updatedb normally runs daily. An older database is likely to miss
+// recently installed JDKs.
+const locateMaxAge = 48 * time.Hour
+
+var (
+	mlocateMagic  = []byte("\x00mlocate")
+	plocateMagic  = []byte("\x00plocate")
+	locate02Magic = []byte("\x00LOCATE02\x00")
+)
+
+// IndexSource reports the libjvm files named by some index of the
+// filesystem instead of walking it
+type IndexSource struct {
+	name     string
+	libjvm   string
+	locateDB string
+	dpkgDir  string
+}
+
+// OpenIndexSource finds the index to use for the requested type. It fails
+// if the index is missing, stale or of unsupported format. With -sysroot,
+// the indexes of the scanned system are used.
+func OpenIndexSource(indexType IndexType, libjvmFileName string, sysroot string) (*IndexSource, error) {
+	src := &IndexSource{libjvm: libjvmFileName}
+	dpkgDir := path.Join("/", sysroot, dpkgInfoDir)
+	switch indexType {
+	case INDEX_LOCATE, INDEX_AUTO:
+		db, e := findLocateDB(sysroot)
+		if e != nil {
+			// The dpkg lists alone still find the packaged JDKs
+			if indexType == INDEX_AUTO && dirExists(dpkgDir) {
+				src.dpkgDir = dpkgDir
+				src.name = "dpkg (" + e.Error() + ")"
+				return src, nil
+			}
+			return nil, e
+		}
+		src.locateDB = db
+		src.name = "locate:" + db
+		// Packaged JDKs are cheap to add and are never stale
+		if indexType == INDEX_AUTO && dirExists(dpkgDir) {
+			src.dpkgDir = dpkgDir
+			src.name += ",dpkg"
+		}
+	case INDEX_DPKG:
+		if !dirExists(dpkgDir) {
+			return nil, errors.New("dpkg index not found: " + dpkgDir)
+		}
+		src.dpkgDir = dpkgDir
+		src.name = "dpkg"
+	default:
+		return nil, fmt.Errorf("unknown index type: %s", indexType)
+	}
+	return src, nil
+}
+
+func (src *IndexSource) Name() string {
+	return src.name
+}
+
+// Candidates reports every path of the index that ends with the libjvm
+// file name
+func (src *IndexSource) Candidates(found func(p string)) error {
+	match := func(p string) {
+		if path.Base(p) == src.libjvm {
+			found(p)
+		}
+	}
+	if src.locateDB != "" {
+		if e := readLocateDB(src.locateDB, match); e != nil {
+			return e
+		}
+	}
+	if src.dpkgDir != "" {
+		if e := readDpkgLists(src.dpkgDir, match); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func dirExists(p string) bool {
+	info, e := os.Stat(p)
+	return e == nil && info.IsDir()
+}
+
+// PlocateError tells that the only locate database found is a plocate one,
+// which cannot be read
+type PlocateError struct {
+	Path string
+}
+
+func (e *PlocateError) Error() string {
+	return "locate index " + e.Path + ": plocate format not supported"
+}
+
+func findLocateDB(sysroot string) (string, error) {
+	plocateDB := ""
+	for _, db := range locateDBPaths {
+		db = path.Join("/", sysroot, db)
+		info, e := os.Stat(db)
+		if e != nil {
+			continue
+		}
+		magic, e := readMagic(db, len(locate02Magic))
+		if e != nil {
+			return "", e
+		}
+		if bytes.HasPrefix(magic, plocateMagic) {
+			// plocate compresses its database with zstd; look for another one
+			plocateDB = db
+			continue
+		}
+		if age := time.Since(info.ModTime()); age > locateMaxAge {
+			return "", fmt.Errorf("locate index %s is stale: updated %s ago", db, age.Truncate(time.Minute))
+		}
+		return db, nil
+	}
+	if plocateDB != "" {
+		return "", &PlocateError{plocateDB}
+	}
+	return "", errors.New("locate index not found")
+}
+
+func readMagic(p string, n int) ([]byte, error) {
+	f, e := os.Open(p)
+	if e != nil {
+		return nil, e
+	}
+	defer closeFile(f)
+	magic := make([]byte, n)
+	n, e = io.ReadFull(f, magic)
+	if e == io.ErrUnexpectedEOF {
+		e = nil
+	}
+	return magic[:n], e
+}
+
+func readLocateDB(db string, found func(p string)) error {
+	f, e := os.Open(db)
+	if e != nil {
+		return e
+	}
+	defer closeFile(f)
+
+	r := bufio.NewReaderSize(f, 1024*1024)
+	magic, e := r.Peek(len(locate02Magic))
+	if e != nil {
+		return fmt.Errorf("%s: %s", db, e.Error())
+	}
+	if bytes.HasPrefix(magic, mlocateMagic) {
+		e = readMlocateDB(r, found)
+	} else if bytes.Equal(magic, locate02Magic) {
+		e = readLocate02DB(r, found)
+	} else {
+		e = errors.New("unsupported format")
+	}
+	if e != nil {
+		return fmt.Errorf("%s: %s", db, e.Error())
+	}
+	return nil
+}
+
+// readMlocateDB reads a database of mlocate(1). It starts with a header
+//
+//	magic[8] config_size:u32 version:u8 require_visibility:u8 pad[2] root\0 config[config_size]
+//
+// followed by the directories, each of them being
+//
+//	mtime_sec:u64 mtime_nsec:u32 pad[4] path\0 (type:u8 [name\0])...
+//
+// where the entry type is 0 for files, 1 for subdirectories and 2 ends
+// the directory.
+func readMlocateDB(r *bufio.Reader, found func(p string)) error {
+	var header [16]byte
+	if _, e := io.ReadFull(r, header[:]); e != nil {
+		return e
+	}
+	configSize := binary.BigEndian.Uint32(header[8:12])
+	if _, e := r.ReadString(0); e != nil {
+		return e
+	}
+	if _, e := r.Discard(int(configSize)); e != nil {
+		return e
+	}
+
+	var dirHeader [16]byte
+	for {
+		if _, e := io.ReadFull(r, dirHeader[:]); e != nil {
+			if e == io.EOF {
+				return nil
+			}
+			return e
+		}
+		dir, e := readCString(r)
+		if e != nil {
+			return e
+		}
+		for {
+			entryType, e := r.ReadByte()
+			if e != nil {
+				return e
+			}
+			if entryType == 2 {
+				break
+			}
+			name, e := readCString(r)
+			if e != nil {
+				return e
+			}
+			if entryType == 0 {
+				found(path.Join(dir, name))
+			}
+		}
+	}
+}
+
+// readLocate02DB reads a front-compressed database of GNU findutils
+// locate(1). Every entry is a signed difference to the length of the
+// common prefix with the previous path (one byte, or 0x80 followed by
+// two bytes) and the rest of the path.
+func readLocate02DB(r *bufio.Reader, found func(p string)) error {
+	if _, e := r.Discard(len(locate02Magic)); e != nil {
+		return e
+	}
+	var prev string
+	prefix := 0
+	for {
+		b, e := r.ReadByte()
+		if e != nil {
+			if e == io.EOF {
+				return nil
+			}
+			return e
+		}
+		diff := int(int8(b))
+		if b == 0x80 {
+			var wide [2]byte
+			if _, e := io.ReadFull(r, wide[:]); e != nil {
+				return e
+			}
+			diff = int(int16(binary.BigEndian.Uint16(wide[:])))
+		}
+		prefix += diff
+		if prefix < 0 || prefix > len(prev) {
+			return errors.New("corrupted database")
+		}
+		suffix, e := readCString(r)
+		if e != nil {
+			return e
+		}
+		prev = prev[:prefix] + suffix
+		found(prev)
+	}
+}
+
+func readCString(r *bufio.Reader) (string, error) {
+	s, e := r.ReadString(0)
+	if e != nil {
+		if e == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", e
+	}
+	return s[:len(s)-1], nil
+}
+
+// readDpkgLists reads the lists of files installed by every dpkg package
+func readDpkgLists(dir string, found func(p string)) error {
+	entries, e := ioutil.ReadDir(dir)
+	if e != nil {
+		return e
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".list") {
+			continue
+		}
+		f, e := os.Open(path.Join(dir, entry.Name()))
+		if e != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			found(scanner.Text())
+		}
+		closeFile(f)
+	}
+	return nil
+}
+
+// checkIndexCandidates reports the libjvm files named by the index that
+// still exist and are not excluded from the scan. The paths of the index
+// are the ones of the scanned system.
+func (s *Scanner) checkIndexCandidates(index *IndexSource, found func(libjvm string)) error {
+	reported := make(map[string]bool)
+	fsys := s.config.fileSystem()
+	return index.Candidates(func(name string) {
+		p := s.config.hostPath(name)
+		if !s.budget.CountFile() || reported[p] || !s.underRoots(p) || !s.config.filter.Admits(p, false) {
+			return
+		}
+		if m := s.mounts.MountOf(p); m != nil && s.quickPrune(m.MountPoint, true) {
+			return
+		}
+		s.progress.setCurrentPath(p)
+		if info, e := fsys.Stat(name); e == nil && info.Mode().IsRegular() {
+			reported[p] = true
+			found(p)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestOpenIndexSourcePlocate(t *testing.T) {
+	sysroot := tempDir(t)
+	defer os.RemoveAll(sysroot)
+	db := path.Join(sysroot, "var/lib/plocate/plocate.db")
+	if e := os.MkdirAll(path.Dir(db), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(db, append(plocateMagic, 0, 0, 0, 0), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	_, e := OpenIndexSource(INDEX_LOCATE, "libjvm.so", sysroot)
+	if pe, ok := e.(*PlocateError); !ok || pe.Path != db {
+		t.Errorf("got error %v, want a PlocateError for %s", e, db)
+	}
+
+	// With auto, the dpkg lists are used instead
+	if e := os.MkdirAll(path.Join(sysroot, dpkgInfoDir), 0755); e != nil {
+		t.Fatal(e)
+	}
+	src, e := OpenIndexSource(INDEX_AUTO, "libjvm.so", sysroot)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if src.dpkgDir == "" || src.locateDB != "" {
+		t.Errorf("got source %s", src.Name())
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// JavaVersion is a Java version string split into its parts. Versions up
+// to 8 are written 1.<feature>.0_<update>-b<build>, while the later ones
+// follow JEP 223 and JEP 322:
+//
+//	<feature>.<interim>.<update>.<patch>[-<pre>][+<build>][-<opt>]
+//
+// Both are held the same way, so that 1.8.0_292 orders before 11.0.12+7.
+type JavaVersion struct {
+	Feature int
+	Interim int
+	Update  int
+	Patch   int
+	Build   int
+	Pre     string
+	Opt     string
+	Legacy  bool
+}
+
+var errBadJavaVersion = errors.New("not a Java version")
+
+func ParseJavaVersion(s string) (JavaVersion, error) {
+	if strings.HasPrefix(s, "1.") {
+		return parseLegacyJavaVersion(s)
+	}
+	var v JavaVersion
+
+	end := strings.IndexAny(s, "-+")
+	if end < 0 {
+		end = len(s)
+	}
+	numbers, e := parseVersionNumbers(s[:end])
+	if e != nil {
+		return v, e
+	}
+	numbers = append(numbers, 0, 0, 0)
+	v.Feature, v.Interim, v.Update, v.Patch = numbers[0], numbers[1], numbers[2], numbers[3]
+
+	rest := s[end:]
+	if strings.HasPrefix(rest, "-") {
+		end = strings.IndexAny(rest[1:], "-+") + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		// A pre-release is made of letters and digits only. Anything else,
+		// like -zing_22.08.0.0-b2-product-linux-X86_64, is optional
+		// information.
+		if isAlphanumeric(rest[1:end]) {
+			v.Pre, rest = rest[1:end], rest[end:]
+		}
+	}
+	if strings.HasPrefix(rest, "+") {
+		end = strings.IndexByte(rest, '-')
+		if end < 0 {
+			end = len(rest)
+		}
+		if end > 1 {
+			if v.Build, e = strconv.Atoi(rest[1:end]); e != nil {
+				return v, errBadJavaVersion
+			}
+		}
+		rest = rest[end:]
+	}
+	if strings.HasPrefix(rest, "-") {
+		v.Opt = rest[1:]
+	}
+	return v, nil
+}
+
+// parseLegacyJavaVersion parses versions like 1.8.0_292-b10, where the
+// part after the dash may hold a build, ea, or anything the vendor adds
+func parseLegacyJavaVersion(s string) (JavaVersion, error) {
+	v := JavaVersion{Legacy: true}
+
+	end := strings.IndexByte(s, '-')
+	if end < 0 {
+		end = len(s)
+	}
+	vnum, rest := s[:end], s[end:]
+	if idx := strings.IndexByte(vnum, '_'); idx >= 0 {
+		update, e := strconv.Atoi(vnum[idx+1:])
+		if e != nil {
+			return v, errBadJavaVersion
+		}
+		v.Update, vnum = update, vnum[:idx]
+	}
+	numbers, e := parseVersionNumbers(vnum)
+	if e != nil || len(numbers) < 2 {
+		return v, errBadJavaVersion
+	}
+	v.Feature = numbers[1]
+
+	var opt []string
+	for _, part := range strings.Split(strings.TrimPrefix(rest, "-"), "-") {
+		switch {
+		case part == "":
+		case part == "ea" && v.Build == 0 && len(opt) == 0:
+			v.Pre = part
+		case len(part) > 1 && part[0] == 'b' && v.Build == 0 && isDigits(part[1:]):
+			v.Build, _ = strconv.Atoi(part[1:])
+		default:
+			opt = append(opt, part)
+		}
+	}
+	v.Opt = strings.Join(opt, "-")
+	return v, nil
+}
+
+func parseVersionNumbers(s string) ([]int, error) {
+	var numbers []int
+	for _, part := range strings.Split(s, ".") {
+		if !isDigits(part) {
+			return nil, errBadJavaVersion
+		}
+		n, e := strconv.Atoi(part)
+		if e != nil {
+			return nil, errBadJavaVersion
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as, or newer
+// than other. A pre-release is older than its release. The optional
+// information is not compared.
+func (v JavaVersion) Compare(other JavaVersion) int {
+	a := []int{v.Feature, v.Interim, v.Update, v.Patch}
+	b := []int{other.Feature, other.Interim, other.Update, other.Patch}
+	for i := range a {
+		if a[i] != b[i] {
+			return compareInts(a[i], b[i])
+		}
+	}
+	switch {
+	case v.Pre != "" && other.Pre == "":
+		return -1
+	case v.Pre == "" && other.Pre != "":
+		return 1
+	case v.Pre != other.Pre:
+		return strings.Compare(v.Pre, other.Pre)
+	}
+	return compareInts(v.Build, other.Build)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Short returns the version the way java.version has it, like 1.8.0_292
+// or 11.0.12
+func (v JavaVersion) Short() string {
+	if v.Legacy {
+		s := "1." + strconv.Itoa(v.Feature) + ".0"
+		if v.Update != 0 {
+			s += "_" + strconv.Itoa(v.Update)
+		}
+		return s
+	}
+	// Trailing zeros are left out
+	numbers := []int{v.Feature, v.Interim, v.Update, v.Patch}
+	for len(numbers) > 1 && numbers[len(numbers)-1] == 0 {
+		numbers = numbers[:len(numbers)-1]
+	}
+	var parts []string
+	for _, n := range numbers {
+		parts = append(parts, strconv.Itoa(n))
+	}
+	return strings.Join(parts, ".")
+}
+
+func (v JavaVersion) String() string {
+	s := v.Short()
+	if v.Legacy {
+		if v.Pre != "" {
+			s += "-" + v.Pre
+		}
+		if v.Opt != "" {
+			s += "-" + v.Opt
+		}
+		if v.Build != 0 {
+			s += "-b" + strconv.Itoa(v.Build)
+		}
+		return s
+	}
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != 0 {
+		s += "+" + strconv.Itoa(v.Build)
+	}
+	if v.Opt != "" {
+		if v.Build == 0 && v.Pre == "" {
+			s += "+"
+		}
+		s += "-" + v.Opt
+	}
+	return s
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseJavaVersion(t *testing.T) {
+	tests := []struct {
+		s     string
+		want  JavaVersion
+		short string
+	}{
+		{"1.8.0_292", JavaVersion{Feature: 8, Update: 292, Legacy: true}, "1.8.0_292"},
+		{"1.8.0_292-b10", JavaVersion{Feature: 8, Update: 292, Build: 10, Legacy: true}, "1.8.0_292"},
+		{"1.8.0", JavaVersion{Feature: 8, Legacy: true}, "1.8.0"},
+		{"1.7.0_80-ea", JavaVersion{Feature: 7, Update: 80, Pre: "ea", Legacy: true}, "1.7.0_80"},
+		{"1.8.0_302-zing_21.08.0.0-b2", JavaVersion{Feature: 8, Update: 302, Build: 2, Opt: "zing_21.08.0.0", Legacy: true}, "1.8.0_302"},
+		{"9-ea", JavaVersion{Feature: 9, Pre: "ea"}, "9"},
+		{"11.0.2+9", JavaVersion{Feature: 11, Update: 2, Build: 9}, "11.0.2"},
+		{"11.0.12+7-LTS", JavaVersion{Feature: 11, Update: 12, Build: 7, Opt: "LTS"}, "11.0.12"},
+		{"17+35", JavaVersion{Feature: 17, Build: 35}, "17"},
+		{"17.0.4.1+1", JavaVersion{Feature: 17, Update: 4, Patch: 1, Build: 1}, "17.0.4.1"},
+		{"21-ea+22", JavaVersion{Feature: 21, Pre: "ea", Build: 22}, "21"},
+		{"17.0.4-zing_22.08.0.0-b2-product-linux-X86_64", JavaVersion{Feature: 17, Update: 4, Opt: "zing_22.08.0.0-b2-product-linux-X86_64"}, "17.0.4"},
+		{"11.0.8-zing_20.08.0.0-b3-product-linux-X86_64", JavaVersion{Feature: 11, Update: 8, Opt: "zing_20.08.0.0-b3-product-linux-X86_64"}, "11.0.8"},
+		{"9-ea.1", JavaVersion{Feature: 9, Opt: "ea.1"}, "9"},
+		{"11.0.12+7-Zulu", JavaVersion{Feature: 11, Update: 12, Build: 7, Opt: "Zulu"}, "11.0.12"},
+		{"11.0.12+-internal", JavaVersion{Feature: 11, Update: 12, Opt: "internal"}, "11.0.12"},
+	}
+	for _, test := range tests {
+		v, e := ParseJavaVersion(test.s)
+		if e != nil {
+			t.Errorf("%s: %v", test.s, e)
+			continue
+		}
+		if v != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.s, v, test.want)
+		}
+		if short := v.Short(); short != test.short {
+			t.Errorf("%s: got short %s, want %s", test.s, short, test.short)
+		}
+	}
+}
+
+func TestParseJavaVersionErrors(t *testing.T) {
+	for _, s := range []string{"", "1.x", "1.8.0_x", "11.0.x", "11..2", "11+x", "zulu11"} {
+		if v, e := ParseJavaVersion(s); e == nil {
+			t.Errorf("%q: got %+v, want an error", s, v)
+		}
+	}
+}
+
+func TestJavaVersionString(t *testing.T) {
+	for _, s := range []string{"1.8.0_292-b10", "1.7.0_80-ea", "9-ea", "11.0.2+9", "11.0.12+7-LTS", "17+35", "21-ea+22", "11.0.12+-internal"} {
+		v, e := ParseJavaVersion(s)
+		if e != nil {
+			t.Errorf("%s: %v", s, e)
+			continue
+		}
+		if got := v.String(); got != s {
+			t.Errorf("%s: got %s", s, got)
+		}
+	}
+}
+
+func TestJavaVersionCompare(t *testing.T) {
+	// In ascending order
+	ordered := []string{
+		"1.7.0_80",
+		"1.8.0",
+		"1.8.0_292-b09",
+		"1.8.0_292-b10",
+		"1.8.0_302",
+		"9-ea",
+		"9",
+		"11.0.2+9",
+		"11.0.12+7",
+		"11.0.20-ea",
+		"11.0.20",
+		"11.0.20+8",
+		"11.0.20.1+1",
+		"17+35",
+		"17.0.4",
+	}
+	var versions []JavaVersion
+	for _, s := range ordered {
+		v, e := ParseJavaVersion(s)
+		if e != nil {
+			t.Fatalf("%s: %v", s, e)
+		}
+		versions = append(versions, v)
+	}
+	for i := range versions {
+		for j := range versions {
+			want := compareInts(i, j)
+			if got := versions[i].Compare(versions[j]); got != want {
+				t.Errorf("%s vs %s: got %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestJavaVersionCompareIgnoresOpt(t *testing.T) {
+	a, _ := ParseJavaVersion("11.0.12+7-LTS")
+	b, _ := ParseJavaVersion("11.0.12+7")
+	if c := a.Compare(b); c != 0 {
+		t.Errorf("got %d, want 0", c)
+	}
+}
+
+// The Zing builds are releases, not pre-releases
+func TestJavaVersionCompareZing(t *testing.T) {
+	zing, _ := ParseJavaVersion("11.0.8-zing_20.08.0.0-b3-product-linux-X86_64")
+	release, _ := ParseJavaVersion("11.0.8")
+	if c := zing.Compare(release); c != 0 {
+		t.Errorf("got %d, want 0", c)
+	}
+}
+
+func TestReportedOlderThanZing(t *testing.T) {
+	inst := readStringsFixture(t, libjvmStringFixtures[2].strings)
+	for _, test := range []struct {
+		olderThan string
+		want      bool
+	}{
+		{"11.0.8", false},
+		{"11.0.9", true},
+		{"11.0.7", false},
+	} {
+		v, _ := ParseJavaVersion(test.olderThan)
+		if got := reported(inst, &Config{olderThan: &v}); got != test.want {
+			t.Errorf("%s with -olderthan=%s: got %v, want %v", inst.VersionInfo.RuntimeVersion, test.olderThan, got, test.want)
+		}
+	}
+}
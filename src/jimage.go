@@ -0,0 +1,294 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// A jimage file, such as lib/modules, holds the modules of a JDK 9 and later
+// runtime, including the ones made by jlink. It starts with an index in the
+// byte order of the platform it was made for:
+//
+//	header    magic, version, flags, resource count, table length,
+//	          locations size and strings size, 4 bytes each
+//	redirect  table length 4 byte entries of a perfect hash of the names
+//	offsets   table length 4 byte offsets of the locations
+//	locations the attributes of each resource
+//	strings   the NUL terminated strings the attributes refer to
+//
+// The contents of the resources follow the index.
+const (
+	jimageMagic          = 0xCAFEDADA
+	jimageMajorVersion   = 1
+	jimageHeaderSize     = 7 * 4
+	jimageHashMultiplier = 0x01000193
+
+	// A compressed resource starts with a header of its own: magic,
+	// compressed and uncompressed sizes of 8 bytes, the offsets of the
+	// names of the decompressor and of its configuration, and a terminal
+	// flag byte. Compressions may be stacked.
+	jimageCompressedMagic      = 0xCAFEFAFA
+	jimageCompressedHeaderSize = 29
+)
+
+// Attributes of a location, encoded as a byte holding the kind and the
+// length of the value, followed by the big endian value
+const (
+	jimageAttrEnd = iota
+	jimageAttrModule
+	jimageAttrParent
+	jimageAttrBase
+	jimageAttrExtension
+	jimageAttrOffset
+	jimageAttrCompressed
+	jimageAttrUncompressed
+	jimageAttrCount
+)
+
+var errNotJImage = errors.New("not a jimage file")
+
+// JImage is an open jimage file
+type JImage struct {
+	file      File
+	order     binary.ByteOrder
+	redirect  []int32
+	offsets   []uint32
+	locations []byte
+	strings   []byte
+	indexSize int64
+}
+
+type jimageLocation [jimageAttrCount]uint64
+
+func OpenJImage(fsys FileSystem, filename string) (*JImage, error) {
+	f, err := fsys.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	image, err := readJImage(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.New(filename + ": " + err.Error())
+	}
+	return image, nil
+}
+
+func readJImage(f File) (*JImage, error) {
+	header := make([]byte, jimageHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, errNotJImage
+	}
+	image := &JImage{file: f}
+	switch {
+	case binary.LittleEndian.Uint32(header) == jimageMagic:
+		image.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == jimageMagic:
+		image.order = binary.BigEndian
+	default:
+		return nil, errNotJImage
+	}
+	field := func(i int) int64 {
+		return int64(image.order.Uint32(header[i*4:]))
+	}
+	if major := field(1) >> 16; major != jimageMajorVersion {
+		return nil, fmt.Errorf("unsupported jimage version %d", major)
+	}
+	tableLength, locationsSize, stringsSize := field(4), field(5), field(6)
+	image.indexSize = jimageHeaderSize + tableLength*8 + locationsSize + stringsSize
+	if fi, err := f.Stat(); err != nil || fi.Size() < image.indexSize {
+		return nil, errors.New("truncated jimage file")
+	}
+
+	index := make([]byte, image.indexSize-jimageHeaderSize)
+	if _, err := f.ReadAt(index, jimageHeaderSize); err != nil {
+		return nil, err
+	}
+	image.redirect = make([]int32, tableLength)
+	image.offsets = make([]uint32, tableLength)
+	for i := int64(0); i < tableLength; i++ {
+		image.redirect[i] = int32(image.order.Uint32(index[i*4:]))
+		image.offsets[i] = image.order.Uint32(index[(tableLength+i)*4:])
+	}
+	image.locations = index[tableLength*8 : tableLength*8+locationsSize]
+	image.strings = index[tableLength*8+locationsSize:]
+	return image, nil
+}
+
+func (j *JImage) Close() error {
+	return j.file.Close()
+}
+
+// jimageHash is the hash of the names in the redirect table
+func jimageHash(name string, seed uint32) uint32 {
+	for i := 0; i < len(name); i++ {
+		seed = (seed * jimageHashMultiplier) ^ uint32(name[i])
+	}
+	return seed & 0x7FFFFFFF
+}
+
+func (j *JImage) location(offset uint32) (jimageLocation, error) {
+	var loc jimageLocation
+	for i := int(offset); i < len(j.locations); {
+		kind, length := int(j.locations[i]>>3), int(j.locations[i]&7)+1
+		i++
+		if kind == jimageAttrEnd {
+			return loc, nil
+		}
+		if kind >= jimageAttrCount || i+length > len(j.locations) {
+			break
+		}
+		for _, b := range j.locations[i : i+length] {
+			loc[kind] = loc[kind]<<8 | uint64(b)
+		}
+		i += length
+	}
+	return loc, errors.New("corrupted jimage location")
+}
+
+func (j *JImage) str(offset uint64) string {
+	if offset >= uint64(len(j.strings)) {
+		return ""
+	}
+	s := j.strings[offset:]
+	if end := bytes.IndexByte(s, 0); end >= 0 {
+		s = s[:end]
+	}
+	return string(s)
+}
+
+// name returns the name of the resource at a location, like
+// /java.base/java/lang/Object.class
+func (j *JImage) name(loc jimageLocation) string {
+	var name string
+	if module := j.str(loc[jimageAttrModule]); module != "" {
+		name = "/" + module + "/"
+	}
+	if parent := j.str(loc[jimageAttrParent]); parent != "" {
+		name += parent + "/"
+	}
+	name += j.str(loc[jimageAttrBase])
+	if extension := j.str(loc[jimageAttrExtension]); extension != "" {
+		name += "." + extension
+	}
+	return name
+}
+
+func (j *JImage) find(name string) (jimageLocation, bool) {
+	count := uint32(len(j.redirect))
+	if count == 0 {
+		return jimageLocation{}, false
+	}
+	index := jimageHash(name, jimageHashMultiplier) % count
+	switch value := j.redirect[index]; {
+	case value < 0:
+		index = uint32(-1 - value)
+	case value > 0:
+		index = jimageHash(name, uint32(value)) % count
+	default:
+		return jimageLocation{}, false
+	}
+	if index >= count {
+		return jimageLocation{}, false
+	}
+	loc, err := j.location(j.offsets[index])
+	if err != nil || j.name(loc) != name {
+		return jimageLocation{}, false
+	}
+	return loc, true
+}
+
+// ReadResource returns the content of a resource, such as
+// /java.base/java/lang/VersionProps.class
+func (j *JImage) ReadResource(name string) ([]byte, error) {
+	loc, ok := j.find(name)
+	if !ok {
+		return nil, errors.New("resource " + name + " not found")
+	}
+	size := loc[jimageAttrCompressed]
+	if size == 0 {
+		size = loc[jimageAttrUncompressed]
+	}
+	if size > 1<<30 {
+		return nil, errors.New("resource " + name + " too large")
+	}
+	data := make([]byte, size)
+	if _, err := j.file.ReadAt(data, j.indexSize+int64(loc[jimageAttrOffset])); err != nil {
+		return nil, err
+	}
+	if loc[jimageAttrCompressed] == 0 {
+		return data, nil
+	}
+	return j.decompress(name, data)
+}
+
+func (j *JImage) decompress(name string, data []byte) ([]byte, error) {
+	for len(data) >= jimageCompressedHeaderSize && j.order.Uint32(data) == jimageCompressedMagic {
+		compressedSize := j.order.Uint64(data[4:])
+		decompressor := j.str(uint64(j.order.Uint32(data[20:])))
+		content := data[jimageCompressedHeaderSize:]
+		if compressedSize < uint64(len(content)) {
+			content = content[:compressedSize]
+		}
+		switch decompressor {
+		case "zip":
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				return nil, err
+			}
+			if data, err = ioutil.ReadAll(r); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("resource %s: %s compression is not supported", name, decompressor)
+		}
+	}
+	return data, nil
+}
+
+// Modules returns the names of the modules held in the image
+func (j *JImage) Modules() []string {
+	var modules []string
+	for _, offset := range j.offsets {
+		loc, err := j.location(offset)
+		if err != nil {
+			continue
+		}
+		// Every module has a module-info class at its top
+		if j.str(loc[jimageAttrParent]) == "" && j.str(loc[jimageAttrBase]) == "module-info" && j.str(loc[jimageAttrExtension]) == "class" {
+			if module := j.str(loc[jimageAttrModule]); module != "" {
+				modules = append(modules, module)
+			}
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// extractJImageResource reads a resource of a jimage file
+func extractJImageResource(fsys FileSystem, filename string, name string) ([]byte, error) {
+	image, err := OpenJImage(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = image.Close() }()
+	return image.ReadResource(name)
+}
+
+// listJImageModules returns the modules held in a jimage file
+func listJImageModules(fsys FileSystem, filename string) ([]string, error) {
+	image, err := OpenJImage(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = image.Close() }()
+	return image.Modules(), nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// A JMOD file is a zip archive following a 4 byte header: "JM" and the
+// major and minor versions of the format
+var jmodMagic = []byte{'J', 'M', 0x01, 0x00}
+
+// Sections of a JMOD file, which are the top directories of its archive
+const (
+	JMOD_CLASSES = "classes"
+	JMOD_LIB     = "lib"
+	JMOD_CONF    = "conf"
+)
+
+// Jmod is an open JMOD file
+type Jmod struct {
+	file    File
+	archive *zip.Reader
+}
+
+func OpenJmod(fsys FileSystem, filename string) (*Jmod, error) {
+	f, err := fsys.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	jmod, err := readJmod(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.New(filename + ": " + err.Error())
+	}
+	return jmod, nil
+}
+
+func readJmod(f File) (*Jmod, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(jmodMagic))
+	if _, err := f.ReadAt(header, 0); err != nil || !bytes.Equal(header, jmodMagic) {
+		return nil, errors.New("not a JMOD file")
+	}
+	size := fi.Size() - int64(len(jmodMagic))
+	archive, err := zip.NewReader(io.NewSectionReader(f, int64(len(jmodMagic)), size), size)
+	if err != nil {
+		return nil, err
+	}
+	return &Jmod{file: f, archive: archive}, nil
+}
+
+func (j *Jmod) Close() error {
+	return j.file.Close()
+}
+
+// List returns the files of a section, relative to it
+func (j *Jmod) List(section string) []string {
+	var files []string
+	prefix := section + "/"
+	for _, archiveEntry := range j.archive.File {
+		if strings.HasPrefix(archiveEntry.Name, prefix) && !strings.HasSuffix(archiveEntry.Name, "/") {
+			files = append(files, strings.TrimPrefix(archiveEntry.Name, prefix))
+		}
+	}
+	return files
+}
+
+// ReadEntry returns the content of an entry, such as
+// classes/java/lang/VersionProps.class
+func (j *Jmod) ReadEntry(name string) ([]byte, error) {
+	for _, archiveEntry := range j.archive.File {
+		if archiveEntry.Name == name {
+			fc, err := archiveEntry.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = fc.Close() }()
+			return ioutil.ReadAll(fc)
+		}
+	}
+	return nil, errors.New("entry " + name + " not found")
+}
+
+// extractJmodEntry reads an entry of a JMOD file
+func extractJmodEntry(fsys FileSystem, filename string, name string) ([]byte, error) {
+	jmod, err := OpenJmod(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = jmod.Close() }()
+	return jmod.ReadEntry(name)
+}
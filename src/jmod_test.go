@@ -0,0 +1,51 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJmodList(t *testing.T) {
+	jmod, e := OpenJmod(hostFS{}, "testdata/java.base.jmod")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = jmod.Close() }()
+	tests := []struct {
+		section string
+		want    []string
+	}{
+		{JMOD_CLASSES, []string{"module-info.class", "java/lang/VersionProps.class"}},
+		{JMOD_LIB, []string{"libjava.so", "server/libjvm.so"}},
+		{JMOD_CONF, []string{"security/java.security"}},
+		{"include", nil},
+	}
+	for _, test := range tests {
+		if got := jmod.List(test.section); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.section, got, test.want)
+		}
+	}
+}
+
+func TestJmodReadEntry(t *testing.T) {
+	data, e := extractJmodEntry(hostFS{}, "testdata/java.base.jmod", "lib/server/libjvm.so")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(data) != "\x7fELF" {
+		t.Errorf("got %q", data)
+	}
+	if _, e := extractJmodEntry(hostFS{}, "testdata/java.base.jmod", "lib/missing.so"); e == nil {
+		t.Error("got no error for a missing entry")
+	}
+}
+
+func TestOpenJmodNotAJmod(t *testing.T) {
+	if _, e := OpenJmod(hostFS{}, "jmod.go"); e == nil {
+		t.Error("got no error for a source file")
+	}
+}
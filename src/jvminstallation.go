@@ -19,7 +19,6 @@ import (
 	"log"
 	"math"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -35,32 +34,74 @@ type JVMVersionInfo struct {
 	VMName         string `json:"java_vm_name"`
 	VMVendor       string `json:"java_vm_vendor"`
 	VMVersion      string `json:"java_vm_version"`
+	VersionDate    string `json:"java_version_date"`
+	VendorVersion  string `json:"vendor_version"`
+	VendorURL      string `json:"vendor_url"`
+	VendorURLBug   string `json:"vendor_url_bug"`
+	VersionNumber  string `json:"version_number"`
+	VersionBuild   string `json:"version_build"`
+	VersionPre     string `json:"version_pre"`
+	VersionOpt     string `json:"version_opt"`
+	// The release of the product of Azul, which is numbered apart from
+	// Java, like zulu11.50.19, zing20.08.0.0-b3 or prime21.07.0.0-b2
+	ProductVersion string `json:"product_version"`
+	// Normalized from the versions above, 0 if unknown
+	Feature int `json:"feature,omitempty"`
+	Update  int `json:"update,omitempty"`
 }
 
+// Kinds of installations other than the ones installed on the host
+const (
+	KIND_ARCHIVE = "archive"
+	KIND_SNAP    = "snap"
+)
+
 type JVMInstallation struct {
 	Host             string `json:"host"`
+	Sysroot          string `json:"sysroot,omitempty"`
+	Kind             string `json:"kind,omitempty"`
+	ArchivePath      string `json:"archive_path,omitempty"`
 	JavaHome         string `json:"java_home"`
 	IsJDK            bool   `json:"is_jdk"`
+	Distribution     string `json:"distribution,omitempty"`
 	LibJVM           string `json:"libjvm"`
 	LibJVMHash       string `json:"libjvm_hash"`
 	rt_jar           string
 	base_jmod        string
-	VersionInfo      JVMVersionInfo `json:"version_info"`
-	RunningInstances int            `json:"running_instances"`
+	modules_image    string
+	fsys             FileSystem
+	VersionInfo      JVMVersionInfo    `json:"version_info"`
+	Release          *ReleaseInfo      `json:"release,omitempty"`
+	Modules          []string          `json:"modules,omitempty"`
+	Confidence       string            `json:"confidence,omitempty"`
+	Provenance       map[string]string `json:"provenance,omitempty"`
+	Disagreements    []Disagreement    `json:"disagreements,omitempty"`
+	RunningInstances int               `json:"running_instances"`
+	ContainerRuntime string            `json:"container_runtime,omitempty"`
+	Layer            string            `json:"layer,omitempty"`
+	Container        string            `json:"container,omitempty"`
+	Images           []ImageRef        `json:"images,omitempty"`
+	Containers       []string          `json:"containers,omitempty"`
+	ImageFile        string            `json:"image_file,omitempty"`
+	Snap             string            `json:"snap,omitempty"`
+	SnapRevision     string            `json:"snap_revision,omitempty"`
 }
 
-func InitJVMInstallation(libjvm string, config *Config) *JVMInstallation {
+// InitJVMInstallation analyses the installation of the given libjvm, which
+// is looked up in fsys
+func InitJVMInstallation(fsys FileSystem, libjvm string, config *Config) *JVMInstallation {
 	var inst JVMInstallation
 
 	hostname, _ := os.Hostname()
 	inst.Host = hostname
+	inst.fsys = fsys
 	inst.LibJVM = libjvm
-	inst.LibJVMHash, _ = md5sum(libjvm)
-	inst.JavaHome = findJavaHome(libjvm)
+	inst.LibJVMHash, _ = md5sum(fsys, libjvm)
+	inst.JavaHome = findJavaHome(fsys, libjvm)
 	inst.VersionInfo = JVMVersionInfo{}
 
 	if inst.JavaHome != "" {
-		if _, err := os.Stat(path.Join(inst.JavaHome, "bin/javac")); err == nil || os.IsExist(err) {
+		if _, err := fsys.Stat(path.Join(inst.JavaHome, "bin/javac")); err == nil || os.IsExist(err) {
 			inst.IsJDK = true
 		}
 		var found bool
@@ -84,35 +125,49 @@ func InitJVMInstallation(libjvm string, config *Config) *JVMInstallation {
 			return nil
 		}
 
-		_ = filepath.Walk(inst.JavaHome, wf)
+		_ = walkFileSystem(fsys, inst.JavaHome, wf)
+		// Runtimes made by jlink have neither rt.jar nor jmods
+		if info, err := fsys.Stat(path.Join(inst.JavaHome, "lib/modules")); err == nil && info.Mode().IsRegular() {
+			inst.modules_image = path.Join(inst.JavaHome, "lib/modules")
+			inst.Modules, _ = listJImageModules(fsys, inst.modules_image)
+		}
+		inst.Release = readReleaseFile(fsys, inst.JavaHome)
 	}
 
-	for {
-		if !config.nojvmrun && inst.JavaHome != "" && readVersionInfoFromOutput(&inst) {
-			break
-		}
-		if readVersionInfoFromStrings(&inst) {
-			break
-		}
-		if inst.rt_jar != "" && readVersionInfoFromRtJar(&inst) {
-			break
-		}
-		if inst.base_jmod != "" && readVersionInfoFromBaseJmod(&inst) {
-			break
-		}
-		break
+	inst.detectVersion(config)
+	if v, ok := inst.VersionInfo.javaVersion(); ok {
+		inst.VersionInfo.Feature = v.Feature
+		inst.VersionInfo.Update = v.Update
 	}
+	inst.Distribution = identifyDistribution(&inst)
 
 	return &inst
 }
 
-func md5sum(path string) (string, error) {
+// source returns the file of the host the installation was found through:
+// its libjvm, or the archive or snap image holding it
+func (inst *JVMInstallation) source() string {
+	if inst.ArchivePath != "" {
+		return inst.ArchivePath
+	}
+	return inst.LibJVM
+}
+
+// key identifies the installation in a report
+func (inst *JVMInstallation) key() string {
+	if inst.ArchivePath != "" {
+		return inst.ArchivePath + "!" + inst.LibJVM
+	}
+	return inst.LibJVM
+}
+
+func md5sum(fsys FileSystem, path string) (string, error) {
 	var md5sum string
-	file, err := os.Open(path)
+	file, err := fsys.Open(path)
 	if err == nil {
-		defer closeFile(file)
+		defer func() { _ = file.Close() }()
 		hash := md5.New()
-		if _, err := io.Copy(hash, file); err != nil {
+		if _, err := io.Copy(hash, throttled(file)); err != nil {
 			return md5sum, err
 		}
 		hashInBytes := hash.Sum(nil)[:16]
@@ -123,31 +178,119 @@ func md5sum(path string) (string, error) {
 
 func (inst *JVMInstallation) Dump(out *os.File) {
 	_, _ = fmt.Fprintln(out, "host:", inst.Host)
+	if inst.Sysroot != "" {
+		_, _ = fmt.Fprintln(out, "sysroot:", inst.Sysroot)
+	}
+	if inst.Kind != "" {
+		_, _ = fmt.Fprintln(out, "kind:", inst.Kind)
+		_, _ = fmt.Fprintln(out, "archive_path:", inst.ArchivePath)
+	}
+	if inst.Snap != "" {
+		_, _ = fmt.Fprintln(out, "snap:", inst.Snap)
+		_, _ = fmt.Fprintln(out, "snap_revision:", inst.SnapRevision)
+	}
 	_, _ = fmt.Fprintln(out, "libjvm:", inst.LibJVM)
 	_, _ = fmt.Fprintln(out, "libjvm_hash:", inst.LibJVMHash)
 	_, _ = fmt.Fprintln(out, "java_home:", inst.JavaHome)
 	_, _ = fmt.Fprintln(out, "is_jdk:", inst.IsJDK)
+	_, _ = fmt.Fprintln(out, "distribution:", inst.Distribution)
 	_, _ = fmt.Fprintln(out, "java_version:", inst.VersionInfo.Version)
+	_, _ = fmt.Fprintln(out, "feature:", inst.VersionInfo.featureColumn())
+	_, _ = fmt.Fprintln(out, "update:", inst.VersionInfo.updateColumn())
 	_, _ = fmt.Fprintln(out, "java_runtime_name:", inst.VersionInfo.RuntimeName)
 	_, _ = fmt.Fprintln(out, "java_runtime_version:", inst.VersionInfo.RuntimeVersion)
 	_, _ = fmt.Fprintln(out, "java_runtime_vendor:", inst.VersionInfo.RuntimeVendor)
 	_, _ = fmt.Fprintln(out, "java_vm_name:", inst.VersionInfo.VMName)
 	_, _ = fmt.Fprintln(out, "java_vm_version:", inst.VersionInfo.VMVersion)
 	_, _ = fmt.Fprintln(out, "java_vm_vendor:", inst.VersionInfo.VMVendor)
+	_, _ = fmt.Fprintln(out, "java_version_date:", inst.VersionInfo.VersionDate)
+	_, _ = fmt.Fprintln(out, "vendor_version:", inst.VersionInfo.VendorVersion)
+	_, _ = fmt.Fprintln(out, "vendor_url:", inst.VersionInfo.VendorURL)
+	_, _ = fmt.Fprintln(out, "vendor_url_bug:", inst.VersionInfo.VendorURLBug)
+	_, _ = fmt.Fprintln(out, "version_number:", inst.VersionInfo.VersionNumber)
+	_, _ = fmt.Fprintln(out, "version_build:", inst.VersionInfo.VersionBuild)
+	_, _ = fmt.Fprintln(out, "version_pre:", inst.VersionInfo.VersionPre)
+	_, _ = fmt.Fprintln(out, "version_opt:", inst.VersionInfo.VersionOpt)
+	_, _ = fmt.Fprintln(out, "product_version:", inst.VersionInfo.ProductVersion)
+	_, _ = fmt.Fprintln(out, "confidence:", inst.Confidence)
+	_, _ = fmt.Fprintln(out, "provenance:", inst.provenanceList())
+	if len(inst.Disagreements) > 0 {
+		_, _ = fmt.Fprintln(out, "disagreements:", disagreementList(inst.Disagreements))
+	}
 	_, _ = fmt.Fprintln(out, "running_instances:", inst.RunningInstances)
+	if len(inst.Modules) > 0 {
+		_, _ = fmt.Fprintln(out, "modules:", strings.Join(inst.Modules, " "))
+	}
+	if r := inst.Release; r != nil {
+		_, _ = fmt.Fprintln(out, "release_java_version:", r.JavaVersion)
+		_, _ = fmt.Fprintln(out, "release_java_version_date:", r.JavaVersionDate)
+		_, _ = fmt.Fprintln(out, "release_implementor:", r.Implementor)
+		_, _ = fmt.Fprintln(out, "release_implementor_version:", r.ImplementorVersion)
+		_, _ = fmt.Fprintln(out, "release_java_runtime_version:", r.JavaRuntimeVersion)
+		_, _ = fmt.Fprintln(out, "release_os_arch:", r.OSArch)
+		_, _ = fmt.Fprintln(out, "release_source:", r.Source)
+		_, _ = fmt.Fprintln(out, "release_modules:", strings.Join(r.Modules, " "))
+	}
+	if inst.ImageFile != "" {
+		_, _ = fmt.Fprintln(out, "image_file:", inst.ImageFile)
+		_, _ = fmt.Fprintln(out, "layer:", inst.Layer)
+		_, _ = fmt.Fprintln(out, "images:", inst.imageList())
+	}
+	if inst.ContainerRuntime != "" {
+		_, _ = fmt.Fprintln(out, "container_runtime:", inst.ContainerRuntime)
+		_, _ = fmt.Fprintln(out, "layer:", inst.Layer)
+		if inst.Container != "" {
+			_, _ = fmt.Fprintln(out, "container:", inst.Container)
+		}
+		_, _ = fmt.Fprintln(out, "images:", inst.imageList())
+		_, _ = fmt.Fprintln(out, "containers:", strings.Join(inst.Containers, " "))
+	}
 	_, _ = fmt.Fprintln(out)
 }
 
+func (inst *JVMInstallation) imageList() string {
+	var images []string
+	for _, ref := range inst.Images {
+		images = append(images, ref.String())
+	}
+	return strings.Join(images, " ")
+}
+
 func (inst *JVMInstallation) DumpCSV(out *os.File) {
 	w := csv.NewWriter(out)
-	w.Write([]string{
+	fields := append([]string(nil),
 		inst.Host, inst.LibJVM,
 		inst.LibJVMHash, inst.JavaHome,
 		strconv.FormatBool(inst.IsJDK), inst.VersionInfo.Version,
 		inst.VersionInfo.RuntimeName, inst.VersionInfo.RuntimeVersion,
 		inst.VersionInfo.RuntimeVendor, inst.VersionInfo.VMName,
 		inst.VersionInfo.VMVersion, inst.VersionInfo.VMVendor,
-		strconv.FormatInt(int64(inst.RunningInstances), 10)})
+		strconv.FormatInt(int64(inst.RunningInstances), 10),
+		inst.ContainerRuntime, inst.Layer,
+		inst.Container, inst.imageList(),
+		strings.Join(inst.Containers, " "), inst.ImageFile,
+		inst.Kind, inst.ArchivePath,
+		inst.Snap, inst.SnapRevision,
+		inst.Sysroot)
+	if r := inst.Release; r != nil {
+		fields = append(fields,
+			r.JavaVersion, r.JavaVersionDate,
+			r.Implementor, r.ImplementorVersion,
+			r.JavaRuntimeVersion, r.OSArch,
+			r.Source, strings.Join(r.Modules, " "))
+	} else {
+		fields = append(fields, make([]string, 8)...)
+	}
+	fields = append(fields,
+		inst.VersionInfo.VersionDate, inst.VersionInfo.VendorVersion,
+		inst.VersionInfo.VendorURL, inst.VersionInfo.VendorURLBug,
+		inst.VersionInfo.VersionNumber, inst.VersionInfo.VersionBuild,
+		inst.VersionInfo.VersionPre, inst.VersionInfo.VersionOpt,
+		inst.VersionInfo.featureColumn(), inst.VersionInfo.updateColumn(),
+		inst.Distribution, inst.VersionInfo.ProductVersion,
+		inst.Confidence, inst.provenanceList(),
+		disagreementList(inst.Disagreements), strings.Join(inst.Modules, " "))
+	w.Write(fields)
 	w.Flush()
 }
 
@@ -160,12 +303,30 @@ func DumpCSVHeader(out *os.File) {
 		"java_runtime_name", "java_runtime_version",
 		"java_runtime_vendor", "java_vm_name",
 		"java_vm_version", "java_vm_vendor",
-		"running_instances"})
+		"running_instances",
+		"container_runtime", "layer",
+		"container", "images",
+		"containers", "image_file",
+		"kind", "archive_path",
+		"snap", "snap_revision",
+		"sysroot",
+		"release_java_version", "release_java_version_date",
+		"release_implementor", "release_implementor_version",
+		"release_java_runtime_version", "release_os_arch",
+		"release_source", "release_modules",
+		"java_version_date", "vendor_version",
+		"vendor_url", "vendor_url_bug",
+		"version_number", "version_build",
+		"version_pre", "version_opt",
+		"feature", "update",
+		"distribution", "product_version",
+		"confidence", "provenance",
+		"disagreements", "modules"})
 	w.Flush()
 }
 
 func readVersionInfoFromRtJar(inst *JVMInstallation) bool {
-	output, err := extractVersionClass(inst.rt_jar)
+	output, err := extractVersionClass(inst.fsys, inst.rt_jar)
 	if err == nil && len(output) != 0 {
 		extractVersionStringsFromClassFileBytes(output, &inst.VersionInfo)
 		return true
@@ -174,7 +335,7 @@ func readVersionInfoFromRtJar(inst *JVMInstallation) bool {
 }
 
 func readVersionInfoFromBaseJmod(inst *JVMInstallation) bool {
-	output, _ := exec.Command("unzip", "-cpq", inst.base_jmod, "classes/java/lang/VersionProps.class").Output()
+	output, _ := extractJmodEntry(inst.fsys, inst.base_jmod, JMOD_CLASSES+"/java/lang/VersionProps.class")
 	if len(output) != 0 {
 		extractVersionStringsFromClassFileBytes(output, &inst.VersionInfo)
 		return true
@@ -182,18 +343,95 @@ func readVersionInfoFromBaseJmod(inst *JVMInstallation) bool {
 	return false
 }
 
-func processStringsFromFile(fileName string, offset int, length int, callback func(str string) bool) error {
-	f, e := os.Open(fileName)
+func readVersionInfoFromModulesImage(inst *JVMInstallation) bool {
+	output, _ := extractJImageResource(inst.fsys, inst.modules_image, "/java.base/java/lang/VersionProps.class")
+	if len(output) != 0 {
+		extractVersionStringsFromClassFileBytes(output, &inst.VersionInfo)
+		return true
+	}
+	return false
+}
+
+// readVersionInfoFromRelease takes the version from the release file of
+// JDK 9 and later
+func readVersionInfoFromRelease(inst *JVMInstallation) bool {
+	if inst.Release.JavaVersion == "" {
+		return false
+	}
+	inst.VersionInfo.Version = inst.Release.JavaVersion
+	inst.VersionInfo.RuntimeVersion = inst.Release.JavaRuntimeVersion
+	inst.VersionInfo.RuntimeVendor = inst.Release.Implementor
+	inst.VersionInfo.VMVendor = inst.Release.Implementor
+	inst.VersionInfo.VersionDate = inst.Release.JavaVersionDate
+	inst.VersionInfo.VendorVersion = inst.Release.ImplementorVersion
+	return true
+}
+
+// javaVersion parses the runtime version, which has the build, or else
+// the version
+func (info *JVMVersionInfo) javaVersion() (JavaVersion, bool) {
+	for _, s := range []string{info.RuntimeVersion, info.Version} {
+		if v, e := ParseJavaVersion(s); e == nil {
+			return v, true
+		}
+	}
+	return JavaVersion{}, false
+}
+
+// featureColumn and updateColumn are empty when the version is unknown
+func (info *JVMVersionInfo) featureColumn() string {
+	if info.Feature == 0 {
+		return ""
+	}
+	return strconv.Itoa(info.Feature)
+}
+
+func (info *JVMVersionInfo) updateColumn() string {
+	if info.Feature == 0 {
+		return ""
+	}
+	return strconv.Itoa(info.Update)
+}
+
+// versionField is a field of JVMVersionInfo, named as in JSON
+type versionField struct {
+	name  string
+	value *string
+}
+
+func (info *JVMVersionInfo) fields() []versionField {
+	return []versionField{
+		{"java_version", &info.Version},
+		{"runtime_name", &info.RuntimeName},
+		{"java_runtime_vendor", &info.RuntimeVendor},
+		{"java_runtime_version", &info.RuntimeVersion},
+		{"java_vm_name", &info.VMName},
+		{"java_vm_vendor", &info.VMVendor},
+		{"java_vm_version", &info.VMVersion},
+		{"java_version_date", &info.VersionDate},
+		{"vendor_version", &info.VendorVersion},
+		{"vendor_url", &info.VendorURL},
+		{"vendor_url_bug", &info.VendorURLBug},
+		{"version_number", &info.VersionNumber},
+		{"version_build", &info.VersionBuild},
+		{"version_pre", &info.VersionPre},
+		{"version_opt", &info.VersionOpt},
+		{"product_version", &info.ProductVersion},
+	}
+}
+
+func processStringsFromFile(fsys FileSystem, fileName string, offset int, length int, callback func(str string) bool) error {
+	f, e := fsys.Open(fileName)
 	if e != nil {
 		return e
 	}
+	defer func() { _ = f.Close() }()
 	_, e = f.Seek(int64(offset), 0)
 	if e != nil {
 		return e
 	}
-	defer closeFile(f)
 
-	r := bufio.NewReader(f)
+	r := bufio.NewReader(throttled(f))
 
 readLoop:
 	for size := length; size > 0; {
@@ -221,33 +459,45 @@ readLoop:
 	return nil
 }
 
+// Azul numbers the releases of its products apart from Java. Zulu has its
+// release in the name of the runtime, like Zulu11.50+19-CA or
+// Zulu 8.54.0.21-CA-linux64, and Zing in the version of the runtime, like
+// 11.0.8-zing_20.08.0.0-b3-product-linux-X86_64. Zing is named Prime since
+// its 21 releases.
+var (
+	zuluVersionRe = regexp.MustCompile(`^Zulu ?([0-9]+(?:[.+][0-9]+)*)`)
+	zingReleaseRe = regexp.MustCompile(`zing_([0-9]+\.[0-9]+\.[0-9]+\.[0-9]+(?:-b[0-9]+)?)`)
+)
+
+const primeFirstRelease = 21
+
 func readVersionInfoFromStrings(inst *JVMInstallation) bool {
 	offset := 0
 	size := math.MaxInt64
 
 	// Only process .rodata section for elf files
-	f, e := elf.Open(inst.LibJVM)
-	if e == nil {
-		s := f.Section(".rodata")
-		_ = f.Close()
-		if s != nil {
-			offset = int(s.Offset)
-			size = int(s.Size)
+	if file, e := inst.fsys.Open(inst.LibJVM); e == nil {
+		if f, e := elf.NewFile(file); e == nil {
+			if s := f.Section(".rodata"); s != nil {
+				offset = int(s.Offset)
+				size = int(s.Size)
+			}
 		}
+		_ = file.Close()
 	}
 
 	re := regexp.MustCompile(`^(?P<name>OpenJDK.* VM) \((?P<ver>.*)\) for .* JRE \((?P<re_name>.*)\) \((?P<re_ver>.*)\), built`)
 	re2 := regexp.MustCompile(`^(?P<name>OpenJDK.* VM) \((?P<ver>.*)\) for .* JRE \((?P<re_name>.*)\), built`)
 	re3 := regexp.MustCompile(`^(?P<name>Java HotSpot\(TM\).* VM) \((?P<ver>.*)\) for .* JRE \((?P<re_name>.*)\), built`)
-	var zing bool
+	var azul bool
+	var zingRelease string
 
-	e = processStringsFromFile(inst.LibJVM, offset, size, func(str string) bool {
+	e := processStringsFromFile(inst.fsys, inst.LibJVM, offset, size, func(str string) bool {
 		if strings.Contains(str, "Azul Systems") {
+			// Zulu, Zing and Prime alike
 			inst.VersionInfo.VMVendor = "Azul Systems, Inc."
 			inst.VersionInfo.RuntimeVendor = "Azul Systems, Inc."
-			inst.VersionInfo.RuntimeName = "Zing Runtime Environment for Java Applications"
-			inst.VersionInfo.VMName = "Zing 64-Bit Tiered VM"
-			zing = true
+			azul = true
 		} else if strings.Contains(str, "AdoptOpenJDK") {
 			inst.VersionInfo.VMVendor = "AdoptOpenJDK"
 			inst.VersionInfo.RuntimeVendor = "AdoptOpenJDK"
@@ -271,16 +521,35 @@ func readVersionInfoFromStrings(inst *JVMInstallation) bool {
 			inst.VersionInfo.RuntimeVersion = match[0][3]
 			inst.VersionInfo.VMVendor = "Oracle Corporation"
 			inst.VersionInfo.RuntimeVendor = "Oracle Corporation"
-		} else if zing && strings.Contains(str, "-zing_") {
+		} else if match := zingReleaseRe.FindStringSubmatch(str); match != nil && strings.Contains(str, "-zing_") {
 			inst.VersionInfo.VMVersion = str
 			inst.VersionInfo.RuntimeVersion = str
+			zingRelease = match[1]
 		}
 
 		return inst.VersionInfo.VMVersion == "" || inst.VersionInfo.VMVendor == ""
 	})
 
+	if zingRelease != "" {
+		inst.VersionInfo.RuntimeName = "Zing Runtime Environment for Java Applications"
+		inst.VersionInfo.VMName = "Zing 64-Bit Tiered VM"
+		product := "zing"
+		if year, _ := strconv.Atoi(zingRelease[:strings.IndexByte(zingRelease, '.')]); year >= primeFirstRelease {
+			product = "prime"
+		}
+		inst.VersionInfo.ProductVersion = product + zingRelease
+	} else if azul {
+		if match := zuluVersionRe.FindStringSubmatch(inst.VersionInfo.RuntimeName); match != nil {
+			inst.VersionInfo.ProductVersion = "zulu" + strings.Replace(match[1], "+", ".", -1)
+		}
+	}
+
 	if e == nil {
 		v := &inst.VersionInfo.RuntimeVersion
+		if version, e := ParseJavaVersion(*v); e == nil {
+			inst.VersionInfo.Version = version.Short()
+			return true
+		}
 		i := strings.IndexAny(*v, "-+_")
 		if i > 0 {
 			inst.VersionInfo.Version = (*v)[:i]
@@ -295,10 +564,9 @@ func readVersionInfoFromStrings(inst *JVMInstallation) bool {
 }
 
 func readVersionInfoFromOutput(inst *JVMInstallation) bool {
-	b, _ := exec.Command(path.Join(inst.JavaHome, "bin/java"), "-XshowSettings:all", "-version").CombinedOutput()
+	b, _ := analysisCommand(path.Join(inst.JavaHome, "bin/java"), "-XshowSettings:all", "-version").CombinedOutput()
 	s := bufio.NewScanner(bytes.NewReader(b))
 	s.Split(bufio.ScanLines)
-	var res bool
 	for s.Scan() {
 		line := strings.TrimSpace(s.Text())
 		if idx := strings.Index(line, " = "); idx >= 0 {
@@ -320,25 +588,33 @@ func readVersionInfoFromOutput(inst *JVMInstallation) bool {
 				inst.VersionInfo.VMVendor = value
 			case "java.vm.version":
 				inst.VersionInfo.VMVersion = value
+			case "java.version.date":
+				inst.VersionInfo.VersionDate = value
+			case "java.vendor.version":
+				inst.VersionInfo.VendorVersion = value
+			case "java.vendor.url":
+				inst.VersionInfo.VendorURL = value
+			case "java.vendor.url.bug":
+				inst.VersionInfo.VendorURLBug = value
 			default:
 			}
 		}
 	}
-	return res
+	return inst.VersionInfo.Version != ""
 }
-func findJavaHome(libjvm string) string {
+func findJavaHome(fsys FileSystem, libjvm string) string {
 	if libjvm == "/" {
 		return ""
 	}
 	p := path.Join(libjvm, "bin/java")
-	if _, err := os.Stat(p); err == nil || os.IsExist(err) {
+	if _, err := fsys.Stat(p); err == nil || os.IsExist(err) {
 		p = path.Join(path.Dir(libjvm), "bin/java")
-		if _, err := os.Stat(p); err == nil || os.IsExist(err) {
+		if _, err := fsys.Stat(p); err == nil || os.IsExist(err) {
 			return path.Dir(libjvm)
 		}
 		return libjvm
 	}
-	return findJavaHome(path.Dir(libjvm))
+	return findJavaHome(fsys, path.Dir(libjvm))
 }
 
 func extractVersionStringsFromClassFileBytes(bytes []byte, info *JVMVersionInfo) {
@@ -354,18 +630,36 @@ func extractVersionStringsFromClassFileBytes(bytes []byte, info *JVMVersionInfo)
 					if e, ok := cp.GetConstantEntry(attr.value).(*ConstantStringEntry); ok {
 						value := fmt.Sprintf("%s", e.Value(cp))
 						if value != "" {
-							switch strings.ToLower(name.String()) {
-							case "Version":
+							// The fields of java.lang.VersionProps, and the
+							// first three of sun.misc.Version
+							switch name.String() {
+							case "java_version":
 								info.Version = value
-							case "RuntimeName":
+							case "java_runtime_name":
 								info.RuntimeName = value
 								info.VMName = value
-							case "vendor":
-								info.RuntimeVendor = value
-								info.VMVendor = value
-							case "RuntimeVersion":
+							case "java_runtime_version":
 								info.RuntimeVersion = value
 								info.VMVersion = value
+							case "java_version_date":
+								info.VersionDate = value
+							case "VENDOR":
+								info.RuntimeVendor = value
+								info.VMVendor = value
+							case "VENDOR_VERSION_STRING":
+								info.VendorVersion = value
+							case "VENDOR_URL":
+								info.VendorURL = value
+							case "VENDOR_URL_BUG":
+								info.VendorURLBug = value
+							case "VERSION_NUMBER":
+								info.VersionNumber = value
+							case "VERSION_BUILD":
+								info.VersionBuild = value
+							case "VERSION_PRE":
+								info.VersionPre = value
+							case "VERSION_OPT":
+								info.VersionOpt = value
 							default:
 							}
 						}
@@ -376,12 +670,20 @@ func extractVersionStringsFromClassFileBytes(bytes []byte, info *JVMVersionInfo)
 	}
 }
 
-func extractVersionClass(filename string) ([]byte, error) {
-	archive, err := zip.OpenReader(filename)
+func extractVersionClass(fsys FileSystem, filename string) ([]byte, error) {
+	f, err := fsys.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(f, fi.Size())
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = archive.Close() }()
 
 	var data []byte
 	for _, archiveEntry := range archive.File {
@@ -402,4 +704,3 @@ func extractVersionClass(filename string) ([]byte, error) {
 
 	return nil, errors.New("entry sun/misc/Version.class not found")
 }
-
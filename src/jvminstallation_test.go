@@ -0,0 +1,151 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Strings of the libjvm of each product line, as found in their .rodata
+var libjvmStringFixtures = []struct {
+	name           string
+	strings        []string
+	version        string
+	vmName         string
+	runtimeName    string
+	vendor         string
+	productVersion string
+}{
+	{
+		name: "zulu8",
+		strings: []string{
+			`OpenJDK 64-Bit Server VM (25.292-b10) for linux-amd64 JRE (Zulu 8.54.0.21-CA-linux64) (1.8.0_292-b10), built on Apr 15 2021 08:30:35 by "zulu_re" with gcc 4.4.7 20120313`,
+			"Azul Systems, Inc.",
+		},
+		version:        "1.8.0_292",
+		vmName:         "OpenJDK 64-Bit Server VM",
+		runtimeName:    "Zulu 8.54.0.21-CA-linux64",
+		vendor:         "Azul Systems, Inc.",
+		productVersion: "zulu8.54.0.21",
+	},
+	{
+		name: "zulu11",
+		strings: []string{
+			"Azul Systems, Inc.",
+			`OpenJDK 64-Bit Server VM (11.0.12+7-LTS) for linux-amd64 JRE (Zulu11.50+19-CA) (11.0.12+7-LTS), built on Jul  6 2021 07:57:23 by "zulu_re" with gcc 10.2.0`,
+		},
+		version:        "11.0.12",
+		vmName:         "OpenJDK 64-Bit Server VM",
+		runtimeName:    "Zulu11.50+19-CA",
+		vendor:         "Azul Systems, Inc.",
+		productVersion: "zulu11.50.19",
+	},
+	{
+		name: "zing20",
+		strings: []string{
+			"Azul Systems, Inc.",
+			"11.0.8-zing_20.08.0.0-b3-product-linux-X86_64",
+		},
+		version:        "11.0.8",
+		vmName:         "Zing 64-Bit Tiered VM",
+		runtimeName:    "Zing Runtime Environment for Java Applications",
+		vendor:         "Azul Systems, Inc.",
+		productVersion: "zing20.08.0.0-b3",
+	},
+	{
+		name: "prime21",
+		strings: []string{
+			"Azul Systems, Inc.",
+			"1.8.0_312-zing_21.10.0.0-b5-product-linux-X86_64",
+		},
+		version:        "1.8.0_312",
+		vmName:         "Zing 64-Bit Tiered VM",
+		runtimeName:    "Zing Runtime Environment for Java Applications",
+		vendor:         "Azul Systems, Inc.",
+		productVersion: "prime21.10.0.0-b5",
+	},
+	{
+		name: "prime22",
+		strings: []string{
+			"17.0.4-zing_22.08.0.0-b2-product-linux-X86_64",
+			"Azul Systems, Inc.",
+		},
+		version:        "17.0.4",
+		vmName:         "Zing 64-Bit Tiered VM",
+		runtimeName:    "Zing Runtime Environment for Java Applications",
+		vendor:         "Azul Systems, Inc.",
+		productVersion: "prime22.08.0.0-b2",
+	},
+	{
+		name: "openjdk",
+		strings: []string{
+			`OpenJDK 64-Bit Server VM (17.0.2+8-Debian-1) for linux-amd64 JRE (17.0.2+8-Debian-1), built on Jan 20 2022 10:23:11 by "unknown" with gcc 11.2.0`,
+		},
+		version:     "17.0.2",
+		vmName:      "OpenJDK 64-Bit Server VM",
+		runtimeName: "OpenJDK 64-Bit Server VM",
+	},
+}
+
+// writeLibJVMStrings writes a libjvm holding nothing but the given strings
+func writeLibJVMStrings(t *testing.T, dir string, strs []string) string {
+	p := path.Join(dir, "lib/server/libjvm.so")
+	if e := os.MkdirAll(path.Dir(p), 0755); e != nil {
+		t.Fatal(e)
+	}
+	data := "\x00junk\x00" + strings.Join(strs, "\x00") + "\x00"
+	if e := ioutil.WriteFile(p, []byte(data), 0644); e != nil {
+		t.Fatal(e)
+	}
+	return p
+}
+
+func readStringsFixture(t *testing.T, strs []string) *JVMInstallation {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	inst := &JVMInstallation{fsys: hostFS{}, LibJVM: writeLibJVMStrings(t, dir, strs)}
+	if !readVersionInfoFromStrings(inst) {
+		t.Fatalf("no version found in %q", strs)
+	}
+	return inst
+}
+
+func TestReadVersionInfoFromStrings(t *testing.T) {
+	for _, fixture := range libjvmStringFixtures {
+		info := readStringsFixture(t, fixture.strings).VersionInfo
+		if info.Version != fixture.version {
+			t.Errorf("%s: got version %q, want %q", fixture.name, info.Version, fixture.version)
+		}
+		if info.VMName != fixture.vmName {
+			t.Errorf("%s: got VM name %q, want %q", fixture.name, info.VMName, fixture.vmName)
+		}
+		if info.RuntimeName != fixture.runtimeName {
+			t.Errorf("%s: got runtime name %q, want %q", fixture.name, info.RuntimeName, fixture.runtimeName)
+		}
+		if info.VMVendor != fixture.vendor || info.RuntimeVendor != fixture.vendor {
+			t.Errorf("%s: got vendors %q and %q, want %q", fixture.name, info.VMVendor, info.RuntimeVendor, fixture.vendor)
+		}
+		if info.ProductVersion != fixture.productVersion {
+			t.Errorf("%s: got product version %q, want %q", fixture.name, info.ProductVersion, fixture.productVersion)
+		}
+	}
+}
+
+// Every Azul build used to be taken for Zing
+func TestReadVersionInfoFromStringsZuluIsNotZing(t *testing.T) {
+	for _, fixture := range libjvmStringFixtures {
+		if !strings.HasPrefix(fixture.name, "zulu") {
+			continue
+		}
+		info := readStringsFixture(t, fixture.strings).VersionInfo
+		if strings.Contains(info.VMName, "Zing") || strings.Contains(info.RuntimeName, "Zing") {
+			t.Errorf("%s: reported as %q, %q", fixture.name, info.RuntimeName, info.VMName)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -16,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var VERSION = "private build"
@@ -35,6 +37,12 @@ func main() {
 		case CMD_REPORT:
 			cmdReport(config)
 			break
+		case CMD_COVERAGE:
+			cmdCoverage(config)
+			break
+		case CMD_SCAN_IMAGE:
+			cmdScanImage(config)
+			break
 		default:
 			fmt.Println("Unknown command:", config.command)
 			os.Exit(1)
@@ -42,6 +50,16 @@ func main() {
 	}
 }
 
+// reported tells whether the report shows an installation. Installations of
+// an unknown version are left out when filtering by version.
+func reported(info *JVMInstallation, config *Config) bool {
+	if config.olderThan == nil {
+		return true
+	}
+	v, ok := info.VersionInfo.javaVersion()
+	return ok && v.Compare(*config.olderThan) < 0
+}
+
 func cmdReport(config *Config) {
 	if config.wait {
 		lock, e := ScanLock(config)
@@ -73,7 +91,7 @@ func cmdReport(config *Config) {
 		return
 	}
 
-	inUseLibJVM := findInUseLibJVM()
+	inUseLibJVM := make(inUseLibJVMs)
 
 	scanner := bufio.NewScanner(f)
 
@@ -83,49 +101,127 @@ func cmdReport(config *Config) {
 		enc := json.NewEncoder(aw)
 		enc.SetIndent("  ", "  ")
 		for scanner.Scan() {
-			if info, e := unmarshalInfo(scanner.Bytes(), inUseLibJVM); e == nil {
+			if info, e := unmarshalInfo(scanner.Bytes(), inUseLibJVM); e == nil && reported(info, config) {
 				_ = enc.Encode(info)
 			}
 		}
 	} else if config.csv {
 		DumpCSVHeader(os.Stdout)
 		for scanner.Scan() {
-			if info, e := unmarshalInfo(scanner.Bytes(), inUseLibJVM); e == nil {
+			if info, e := unmarshalInfo(scanner.Bytes(), inUseLibJVM); e == nil && reported(info, config) {
 				info.DumpCSV(os.Stdout)
 			}
 		}
 	} else {
 		for scanner.Scan() {
-			if info, e := unmarshalInfo(scanner.Bytes(), inUseLibJVM); e == nil {
+			if info, e := unmarshalInfo(scanner.Bytes(), inUseLibJVM); e == nil && reported(info, config) {
 				info.Dump(os.Stdout)
 			}
 		}
 	}
 }
 
-func unmarshalInfo(bytes []byte, inUseLibJVM map[string]int) (*JVMInstallation, error) {
+func unmarshalInfo(bytes []byte, inUseLibJVM inUseLibJVMs) (*JVMInstallation, error) {
 	var info JVMInstallation
 	e := json.Unmarshal(bytes, &info)
 	if e != nil {
 		return nil, e
 	}
-	for running := range inUseLibJVM {
-		stat1, e1 := os.Stat(running);
-		stat2, e2 := os.Stat(info.LibJVM);
+	if info.ArchivePath != "" {
+		// Nothing runs from an archive
+		return &info, nil
+	}
+	// Paths of installations found with -sysroot are inside of it
+	fsys := sysrootFS(info.Sysroot)
+	inUse := inUseLibJVM.of(info.Sysroot)
+	for running := range inUse {
+		stat1, e1 := fsys.Stat(running)
+		stat2, e2 := fsys.Stat(info.LibJVM)
 		if e1 == nil && e2 == nil && os.SameFile(stat1, stat2) {
-			info.RunningInstances += inUseLibJVM[running]
+			info.RunningInstances += inUse[running]
 		}
 	}
 	return &info, nil
 }
 
+func cmdCoverage(config *Config) {
+	if config.wait {
+		lock, e := ScanLock(config)
+		if e != nil {
+			fmt.Println(e.Error())
+			return
+		}
+		e = lock.Lock()
+		defer lock.Unlock()
+	}
+
+	status := ReadStatus(config)
+	if status == nil || status.Coverage == nil {
+		fmt.Printf("Coverage not found. Run '%s' to generate it first.\n", CMD_START)
+		return
+	}
+	status.Coverage.Report(config)
+}
+
+func cmdScanImage(config *Config) {
+	installations, e := ScanImageArchive(config.imageFile, config)
+	if e != nil {
+		fmt.Println("Error:", e.Error())
+		os.Exit(1)
+	}
+
+	if config.json {
+		if len(installations) == 0 {
+			fmt.Println("[]")
+			return
+		}
+		aw := NewJSONArrayWriter(os.Stdout)
+		defer aw.Close()
+		enc := json.NewEncoder(aw)
+		enc.SetIndent("  ", "  ")
+		for _, info := range installations {
+			_ = enc.Encode(info)
+		}
+	} else if config.csv {
+		DumpCSVHeader(os.Stdout)
+		for _, info := range installations {
+			info.DumpCSV(os.Stdout)
+		}
+	} else if len(installations) == 0 {
+		fmt.Println("No results found")
+	} else {
+		for _, info := range installations {
+			info.Dump(os.Stdout)
+		}
+	}
+}
+
 func cmdStart(config *Config) {
+	if config.dryrun {
+		// Only show what would be scanned
+		scanner, e := NewScanner(config, ioutil.Discard, ioutil.Discard)
+		if e != nil {
+			fmt.Println(e.Error())
+			return
+		}
+		if coverage := scanner.Coverage(); coverage != nil {
+			coverage.Report(config)
+		}
+		return
+	}
+
 	cookie := os.Getenv("SCANJVM_COOKIE")
 
 	_ = os.Setenv("LC_ALL", "C")
 	_ = os.Setenv("SCANJVM_COOKIE", strings.Split(config.cookie, "=")[1])
 
 	if !config.wait && cookie == "" {
+		if config.resume {
+			if _, e := LoadCheckpoint(config); e != nil {
+				fmt.Println(e.Error())
+				return
+			}
+		}
 		signals := make(chan os.Signal, 1)
 		started := make(chan bool, 1)
 		signal.Notify(signals, syscall.SIGUSR1)
@@ -197,34 +293,110 @@ func cmdStart(config *Config) {
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
 	defer lock.Unlock()
+	var checkpoint *Checkpoint
+	if config.resume {
+		// The status of the last scan stays as it is when it cannot be
+		// resumed
+		if checkpoint, e = LoadCheckpoint(config); e != nil {
+			fmt.Println(e.Error())
+			if !config.wait {
+				// Let the parent go on
+				reportStatus()
+			}
+			return
+		}
+	}
+	previous := ReadStatus(config)
 	status := NewStatus(config)
 
+	var outFile, errFile *os.File
+	var written map[string]bool
+	if config.resume {
+		// Keep what the interrupted scan has found and reported
+		errFile, _ = os.OpenFile(config.ErrorFilePath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		outFile, written, e = reopenOutput(config)
+	} else {
+		RemoveCheckpoint(config)
+		outFile, _ = os.Create(config.OutputFilePath())
+		errFile, _ = os.Create(config.ErrorFilePath())
+	}
+
+	var scanner *Scanner
+	if e == nil {
+		scanner, e = NewScanner(config, outFile, errFile)
+	}
+	if scanner != nil {
+		if checkpoint != nil {
+			scanner.Resume(checkpoint, written)
+		}
+		status.Source = scanner.SourceName()
+		status.Coverage = scanner.Coverage()
+		scanner.ExpectDirs(expectedDirs(previous, config))
+	}
+
 	go func() {
 		_ = <-signals
+		if scanner != nil {
+			status.Coverage = scanner.Coverage()
+			status.Progress = scanner.Progress()
+			_ = scanner.Checkpoint().Save(config)
+		}
 		status.SetState(Terminated)
 		lock.Unlock()
 		os.Exit(1)
 	}()
 
+	if e == nil {
+		e = applyPriorities(config)
+	}
+	if config.iorate > 0 {
+		ioLimiter = NewRateLimiter(config.iorate * 1024 * 1024)
+	}
+
 	status.SetState(Running)
 	reportStatus()
 
-	outFile, _ := os.Create(config.OutputFilePath())
-	errFile, _ := os.Create(config.ErrorFilePath())
-
-	e = findFiles(config, func(libjvm string) {
-		if info := InitJVMInstallation(libjvm, config); info != nil {
-			if txt, _ := json.Marshal(info); txt != nil {
-				_, _ = fmt.Fprintln(outFile, string(txt))
+	if e == nil {
+		done := make(chan bool)
+		go func() {
+			ticker := time.NewTicker(progressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					status.UpdateProgress(scanner.Progress())
+					if e := scanner.Checkpoint().Save(config); e != nil {
+						_, _ = fmt.Fprintln(errFile, e.Error())
+					}
+				case <-done:
+					return
+				}
 			}
-		} else {
+		}()
+		e = scanner.Run()
+		close(done)
+		status.Coverage = scanner.Coverage()
+		status.Progress = scanner.Progress()
+
+		if e == nil {
+			RemoveCheckpoint(config)
+		} else if ce := scanner.Checkpoint().Save(config); ce != nil {
+			_, _ = fmt.Fprintln(errFile, ce.Error())
 		}
-	})
+		if se := sortReport(config); se != nil {
+			_, _ = fmt.Fprintln(errFile, se.Error())
+		}
+	}
 
-	if e != nil {
+	var budgetErr *BudgetError
+	if errors.As(e, &budgetErr) {
+		_, _ = fmt.Fprintln(errFile, e.Error())
+		status.SetState(Partial)
+	} else if e != nil {
 		_, _ = fmt.Fprintln(errFile, e.Error())
 		status.SetState(Error)
 	} else {
+		status.checkCoverage()
 		status.SetState(Finished)
 	}
 
@@ -234,13 +406,40 @@ func cmdStart(config *Config) {
 }
 
 func cmdStatus(config *Config) {
+	if !config.follow {
+		if status := currentStatus(config, config.wait); status != nil {
+			status.Report()
+		}
+		return
+	}
+
+	for {
+		status := currentStatus(config, false)
+		if status == nil {
+			return
+		}
+		if !config.json && !config.csv {
+			// Clear the terminal
+			fmt.Print("\033[H\033[2J")
+		}
+		status.Report()
+		if status.State != Running {
+			return
+		}
+		time.Sleep(progressInterval)
+	}
+}
+
+// currentStatus reads the status of the last scan, optionally waiting for a
+// running scan to complete
+func currentStatus(config *Config, wait bool) *Status {
 	lock, e := ScanLock(config)
 	if e != nil {
 		fmt.Println(e.Error())
-		return
+		return nil
 	}
 
-	if config.wait {
+	if wait {
 		e = lock.Lock()
 	} else {
 		e = lock.TryLock()
@@ -262,8 +461,7 @@ func cmdStatus(config *Config) {
 		// Cannot get status ... Will return an empty one
 		status = NewStatus(config)
 	}
-
-	status.Report()
+	return status
 }
 
 func cmdStop(config *Config) {
@@ -281,7 +479,7 @@ func cmdStop(config *Config) {
 				continue
 			}
 			envFile := path.Join("/proc", pidStr, "environ")
-			_ = processStringsFromFile(envFile, 0, math.MaxInt64, func(str string) bool {
+			_ = processStringsFromFile(hostFS{}, envFile, 0, math.MaxInt64, func(str string) bool {
 				if strings.HasPrefix(str, config.cookie) {
 					_ = syscall.Kill(pid, syscall.SIGTERM)
 					return false
@@ -295,4 +493,3 @@ func cmdStop(config *Config) {
 		status.Report()
 	}
 }
-
@@ -0,0 +1,287 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Prefixes of the names of the whiteout entries of image layers
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Most symbolic links followed when resolving one path, as on Linux
+const maxSymlinks = 40
+
+// memFS is a file system tree held in memory, made from the entries of one
+// or more archives applied on top of each other. Contents are only read
+// when the file is opened, unless kept. A memFS is not safe for concurrent
+// use.
+type memFS struct {
+	root *memNode
+	// Reads the contents of a file on first open
+	load func(n *memNode) ([]byte, error)
+	// Tells by name which files to read while applying the archives
+	keep func(name string) bool
+}
+
+// memNode is a file of a memFS. It is its own os.FileInfo.
+type memNode struct {
+	name     string
+	mode     os.FileMode
+	size     int64
+	modTime  time.Time
+	target   string // of symbolic links
+	children map[string]*memNode
+
+	// Where the contents come from: the name of the entry in the archive
+	// with the given index
+	layer  int
+	entry  string
+	data   []byte
+	loaded bool
+}
+
+func newMemFS(load func(n *memNode) ([]byte, error)) *memFS {
+	return &memFS{
+		root: &memNode{name: "/", mode: os.ModeDir | 0755, children: make(map[string]*memNode)},
+		load: load,
+	}
+}
+
+func (n *memNode) Name() string       { return n.name }
+func (n *memNode) Size() int64        { return n.size }
+func (n *memNode) Mode() os.FileMode  { return n.mode }
+func (n *memNode) ModTime() time.Time { return n.modTime }
+func (n *memNode) IsDir() bool        { return n.mode.IsDir() }
+func (n *memNode) Sys() interface{}   { return nil }
+
+// applyLayer adds the entries of an archive to the tree. Whiteout entries
+// remove what lower layers have put in the tree.
+func (fsys *memFS) applyLayer(entries archiveEntries, layer int) error {
+	for {
+		hdr, e := entries.Next()
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			if parent := fsys.dir(dir); parent != nil {
+				for childName, child := range parent.children {
+					if child.layer < layer {
+						delete(parent.children, childName)
+					}
+				}
+			}
+		case strings.HasPrefix(base, whiteoutPrefix):
+			if parent := fsys.dir(dir); parent != nil {
+				delete(parent.children, strings.TrimPrefix(base, whiteoutPrefix))
+			}
+		default:
+			if e = fsys.add(name, hdr, entries, layer); e != nil {
+				return e
+			}
+		}
+	}
+}
+
+// add puts the file of an archive entry in the tree. The contents are read
+// from r if the file is to be kept.
+func (fsys *memFS) add(name string, hdr *tar.Header, r io.Reader, layer int) error {
+	dir, base := path.Split(name)
+	n := &memNode{
+		name:    base,
+		mode:    hdr.FileInfo().Mode(),
+		size:    hdr.Size,
+		modTime: hdr.ModTime,
+		layer:   layer,
+		entry:   name,
+	}
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		n.target = hdr.Linkname
+	case tar.TypeLink:
+		// Hard links refer to an earlier entry of the same archive
+		target, e := fsys.lookup(path.Clean("/"+hdr.Linkname), false)
+		if e != nil || !target.mode.IsRegular() {
+			return nil
+		}
+		n.mode, n.size, n.entry = target.mode, target.size, target.entry
+		n.data, n.loaded = target.data, target.loaded
+	default:
+		if n.mode.IsRegular() && fsys.keep != nil && fsys.keep(base) {
+			data, e := ioutil.ReadAll(throttled(r))
+			if e != nil {
+				return e
+			}
+			n.data, n.loaded = data, true
+		}
+	}
+	if base == "" {
+		// The root directory itself
+		fsys.root.mode, fsys.root.modTime = n.mode, n.modTime
+		return nil
+	}
+	parent := fsys.mkdirAll(dir, layer)
+	if old := parent.children[base]; old != nil && old.IsDir() && n.IsDir() {
+		old.mode, old.modTime, old.layer = n.mode, n.modTime, layer
+		return nil
+	}
+	if n.IsDir() {
+		n.children = make(map[string]*memNode)
+	}
+	parent.children[base] = n
+	return nil
+}
+
+// dir returns the directory of the given path without following symbolic
+// links, or nil if there is none
+func (fsys *memFS) dir(name string) *memNode {
+	n := fsys.root
+	for _, part := range splitPath(name) {
+		if n = n.children[part]; n == nil || !n.IsDir() {
+			return nil
+		}
+	}
+	return n
+}
+
+// mkdirAll returns the directory of the given path, creating the missing
+// ones as part of the given layer
+func (fsys *memFS) mkdirAll(name string, layer int) *memNode {
+	n := fsys.root
+	for _, part := range splitPath(name) {
+		child := n.children[part]
+		if child == nil || !child.IsDir() {
+			child = &memNode{name: part, mode: os.ModeDir | 0755, layer: layer, children: make(map[string]*memNode)}
+			n.children[part] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// lookup finds the file of the given path. Symbolic links are resolved
+// inside the tree, and the last one only if follow is set.
+func (fsys *memFS) lookup(name string, follow bool) (*memNode, error) {
+	parts := splitPath(name)
+	stack := []*memNode{fsys.root}
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		n := stack[len(stack)-1]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if !n.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		child := n.children[part]
+		if child == nil {
+			return nil, os.ErrNotExist
+		}
+		if child.mode&os.ModeSymlink != 0 && (follow || len(parts) > 0) {
+			if links++; links > maxSymlinks {
+				return nil, syscall.ELOOP
+			}
+			if path.IsAbs(child.target) {
+				stack = stack[:1]
+			}
+			parts = append(splitPath(child.target), parts...)
+			continue
+		}
+		stack = append(stack, child)
+	}
+	return stack[len(stack)-1], nil
+}
+
+func (fsys *memFS) node(op string, name string, follow bool) (*memNode, error) {
+	n, e := fsys.lookup(name, follow)
+	if e != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: e}
+	}
+	return n, nil
+}
+
+func (fsys *memFS) Stat(name string) (os.FileInfo, error) {
+	return fsys.node("stat", name, true)
+}
+
+func (fsys *memFS) Lstat(name string) (os.FileInfo, error) {
+	return fsys.node("lstat", name, false)
+}
+
+func (fsys *memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	n, e := fsys.node("readdir", name, true)
+	if e != nil {
+		return nil, e
+	}
+	if !n.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	entries := make([]os.FileInfo, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, child)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (fsys *memFS) Open(name string) (File, error) {
+	n, e := fsys.node("open", name, true)
+	if e != nil {
+		return nil, e
+	}
+	if !n.mode.IsRegular() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	if !n.loaded && fsys.load != nil {
+		data, e := fsys.load(n)
+		if e != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: e}
+		}
+		n.data, n.loaded = data, true
+	}
+	return &memFile{bytes.NewReader(n.data), n}, nil
+}
+
+// memFile is an open file of a memFS
+type memFile struct {
+	*bytes.Reader
+	node *memNode
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return f.node, nil
+}
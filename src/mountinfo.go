@@ -0,0 +1,147 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const mountInfoFilePath = "/proc/self/mountinfo"
+
+type Mount struct {
+	ID         int
+	ParentID   int
+	Dev        string // major:minor
+	Root       string // root of the mount within its filesystem
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+type MountTable struct {
+	mounts []*Mount
+	byPath map[string]*Mount
+}
+
+func ReadMountTable() (*MountTable, error) {
+	f, e := os.Open(mountInfoFilePath)
+	if e != nil {
+		return nil, e
+	}
+	defer closeFile(f)
+
+	table := &MountTable{byPath: make(map[string]*Mount)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m, e := parseMountInfoLine(scanner.Text())
+		if e != nil {
+			return nil, e
+		}
+		table.mounts = append(table.mounts, m)
+		// Later entries shadow earlier ones mounted on the same point
+		table.byPath[m.MountPoint] = m
+	}
+	if e = scanner.Err(); e != nil {
+		return nil, e
+	}
+
+	sort.SliceStable(table.mounts, func(i, j int) bool {
+		return table.mounts[i].MountPoint < table.mounts[j].MountPoint
+	})
+	return table, nil
+}
+
+// parseMountInfoLine parses one line of /proc/<pid>/mountinfo:
+// 36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (*Mount, error) {
+	fields := strings.Fields(line)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(fields) < sep+3 {
+		return nil, errors.New("malformed mountinfo line: " + line)
+	}
+
+	id, e := strconv.Atoi(fields[0])
+	if e != nil {
+		return nil, e
+	}
+	parentID, e := strconv.Atoi(fields[1])
+	if e != nil {
+		return nil, e
+	}
+
+	return &Mount{
+		ID:         id,
+		ParentID:   parentID,
+		Dev:        fields[2],
+		Root:       unescapeMountPath(fields[3]),
+		MountPoint: unescapeMountPath(fields[4]),
+		FSType:     fields[sep+1],
+		Source:     unescapeMountPath(fields[sep+2]),
+	}, nil
+}
+
+// unescapeMountPath decodes the octal escapes (\040 etc.) the kernel
+// uses for blanks and backslashes in mountinfo paths
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, e := strconv.ParseUint(s[i+1:i+4], 8, 8); e == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Lookup returns the mount mounted exactly at the given path, if any
+func (t *MountTable) Lookup(p string) *Mount {
+	return t.byPath[p]
+}
+
+// MountOf returns the mount the given path resides on
+func (t *MountTable) MountOf(p string) *Mount {
+	for p = path.Clean(p); ; p = path.Dir(p) {
+		if m, ok := t.byPath[p]; ok {
+			return m
+		}
+		if p == "/" || p == "." {
+			return nil
+		}
+	}
+}
+
+func fsTypeMatches(fstype string, patterns []string) bool {
+	for _, p := range patterns {
+		if fstype == p || fstype == p+"fs" {
+			return true
+		}
+		// nfs matches nfs4, etc.
+		if strings.HasPrefix(fstype, p) {
+			if _, e := strconv.Atoi(fstype[len(p):]); e == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
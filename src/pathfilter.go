@@ -0,0 +1,127 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathFilter applies the -exclude and -include glob patterns. Patterns are
+// absolute paths matched component by component (as by filepath.Match, so
+// '*' never matches '/'), and a pattern that matches a directory covers
+// everything below it as well.
+//
+// A path is scanned if it is not covered by any -exclude pattern and, when
+// -include patterns are given, it is covered by one of them. Directories
+// that may contain an included path are entered anyway.
+type PathFilter struct {
+	exclude [][]string
+	include [][]string
+	// With -sysroot, patterns are matched against the paths inside it
+	root string
+}
+
+func NewPathFilter(exclude []string, include []string) (*PathFilter, error) {
+	f := &PathFilter{}
+	for _, p := range exclude {
+		components, e := splitPattern(p)
+		if e != nil {
+			return nil, e
+		}
+		f.exclude = append(f.exclude, components)
+	}
+	for _, p := range include {
+		components, e := splitPattern(p)
+		if e != nil {
+			return nil, e
+		}
+		f.include = append(f.include, components)
+	}
+	return f, nil
+}
+
+func splitPattern(pattern string) ([]string, error) {
+	if !path.IsAbs(pattern) {
+		return nil, errors.New("pattern is not an absolute path: " + pattern)
+	}
+	components := splitPath(pattern)
+	for _, c := range components {
+		if _, e := filepath.Match(c, ""); e != nil {
+			return nil, errors.New("bad pattern: " + pattern)
+		}
+	}
+	return components, nil
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean(p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// matchPrefix matches the leading components of p against the pattern and
+// returns how many of them matched
+func matchPrefix(pattern []string, p []string) int {
+	n := 0
+	for n < len(pattern) && n < len(p) {
+		if ok, _ := filepath.Match(pattern[n], p[n]); !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func covers(pattern []string, p []string) bool {
+	return len(p) >= len(pattern) && matchPrefix(pattern, p) == len(pattern)
+}
+
+func mayContain(pattern []string, p []string) bool {
+	return len(p) < len(pattern) && matchPrefix(pattern, p) == len(p)
+}
+
+// components returns the components of p that the patterns match
+func (f *PathFilter) components(p string) []string {
+	if f.root != "" && isSubPath(p, f.root) {
+		p = strings.TrimPrefix(p, f.root)
+	}
+	return splitPath(p)
+}
+
+// Excluded reports whether p is covered by an -exclude pattern
+func (f *PathFilter) Excluded(p string) bool {
+	components := f.components(p)
+	for _, pattern := range f.exclude {
+		if covers(pattern, components) {
+			return true
+		}
+	}
+	return false
+}
+
+// Included reports whether p is selected by the -include patterns. If
+// dir is set, p is also selected when it may contain an included path.
+func (f *PathFilter) Included(p string, dir bool) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	components := f.components(p)
+	for _, pattern := range f.include {
+		if covers(pattern, components) || (dir && mayContain(pattern, components)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admits reports whether p is to be scanned (or entered, if it is a directory)
+func (f *PathFilter) Admits(p string, dir bool) bool {
+	return !f.Excluded(p) && f.Included(p, dir)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often a running scan records its progress in the status file
+const progressInterval = 5 * time.Second
+
+// Progress is a snapshot of a running (or ended) scan
+type Progress struct {
+	DirsVisited int64  `json:"dirs_visited"`
+	CurrentPath string `json:"current_path,omitempty"`
+	Found       int64  `json:"installations_found"`
+	Errors      int64  `json:"errors"`
+	Elapsed     int64  `json:"elapsed"` // seconds
+	ETA         int64  `json:"eta"`     // seconds, -1 if unknown
+}
+
+// progressTracker counts what the scan has done so far. It is safe for
+// concurrent use.
+type progressTracker struct {
+	dirs   int64 // accessed atomically, keep 64-bit aligned
+	found  int64
+	errors int64
+
+	start        time.Time
+	expectedDirs int64
+
+	lock        sync.Mutex
+	currentPath string
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{start: time.Now()}
+}
+
+// resume starts from the progress of an interrupted scan, as if it had
+// not stopped
+func (t *progressTracker) resume(p *Progress) {
+	atomic.AddInt64(&t.dirs, p.DirsVisited)
+	atomic.AddInt64(&t.found, p.Found)
+	atomic.AddInt64(&t.errors, p.Errors)
+	t.start = t.start.Add(-time.Duration(p.Elapsed) * time.Second)
+}
+
+func (t *progressTracker) visitDir(dir string) {
+	atomic.AddInt64(&t.dirs, 1)
+	t.setCurrentPath(dir)
+}
+
+func (t *progressTracker) setCurrentPath(p string) {
+	t.lock.Lock()
+	t.currentPath = p
+	t.lock.Unlock()
+}
+
+func (t *progressTracker) countFound() {
+	atomic.AddInt64(&t.found, 1)
+}
+
+func (t *progressTracker) countError() {
+	atomic.AddInt64(&t.errors, 1)
+}
+
+func (t *progressTracker) snapshot() *Progress {
+	t.lock.Lock()
+	currentPath := t.currentPath
+	t.lock.Unlock()
+
+	elapsed := time.Since(t.start)
+	p := &Progress{
+		DirsVisited: atomic.LoadInt64(&t.dirs),
+		CurrentPath: currentPath,
+		Found:       atomic.LoadInt64(&t.found),
+		Errors:      atomic.LoadInt64(&t.errors),
+		Elapsed:     int64(elapsed / time.Second),
+		ETA:         -1,
+	}
+	// Assume the same directories as in the previous scan, visited at
+	// the rate seen so far
+	if p.DirsVisited > 0 && p.DirsVisited < t.expectedDirs {
+		remaining := time.Duration(float64(elapsed) * float64(t.expectedDirs-p.DirsVisited) / float64(p.DirsVisited))
+		p.ETA = int64(remaining / time.Second)
+	}
+	return p
+}
+
+// expectedDirs returns the number of directories the previous scan visited
+// if it ran to the end with the same scan set, or 0 if unknown
+func expectedDirs(previous *Status, config *Config) int64 {
+	if previous == nil || previous.State != Finished || previous.Progress == nil {
+		return 0
+	}
+	if scanSetArgs(previous.Args) != scanSetArgs(config.args) {
+		return 0
+	}
+	return previous.Progress.DirsVisited
+}
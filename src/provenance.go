@@ -0,0 +1,131 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Confidence levels of the versions of installations. Running java, or
+// the release file written by the build, tell the version exactly. The
+// constants of the version classes do too, but not always the vendor. The
+// libjvm strings are a guess.
+const (
+	CONFIDENCE_HIGH   = "high"
+	CONFIDENCE_MEDIUM = "medium"
+	CONFIDENCE_LOW    = "low"
+	CONFIDENCE_NONE   = "none"
+)
+
+var sourceConfidence = map[string]string{
+	SOURCE_EXEC:    CONFIDENCE_HIGH,
+	SOURCE_RELEASE: CONFIDENCE_HIGH,
+	SOURCE_RTJAR:   CONFIDENCE_MEDIUM,
+	SOURCE_JMOD:    CONFIDENCE_MEDIUM,
+	SOURCE_JIMAGE:  CONFIDENCE_MEDIUM,
+	SOURCE_STRINGS: CONFIDENCE_LOW,
+}
+
+// takeVersionInfo fills the fields of the version left empty with the ones
+// of info, and records where they come from
+func (inst *JVMInstallation) takeVersionInfo(info *JVMVersionInfo, source string) {
+	taken := info.fields()
+	for i, field := range inst.VersionInfo.fields() {
+		if *field.value == "" && *taken[i].value != "" {
+			*field.value = *taken[i].value
+			if inst.Provenance == nil {
+				inst.Provenance = make(map[string]string)
+			}
+			inst.Provenance[field.name] = source
+		}
+	}
+}
+
+// provenanceList returns the provenance as field=source pairs, in the order
+// of the fields
+func (inst *JVMInstallation) provenanceList() string {
+	var pairs []string
+	for _, field := range inst.VersionInfo.fields() {
+		if source, ok := inst.Provenance[field.name]; ok {
+			pairs = append(pairs, field.name+"="+source)
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Disagreement lists the values that sources of the version give for a
+// field, when they are not all the same
+type Disagreement struct {
+	Field  string            `json:"field"`
+	Values map[string]string `json:"values"`
+}
+
+func (d Disagreement) String() string {
+	var sources []string
+	for source := range d.Values {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var pairs []string
+	for _, source := range sources {
+		pairs = append(pairs, source+"="+d.Values[source])
+	}
+	return d.Field + ": " + strings.Join(pairs, " ")
+}
+
+func disagreementList(disagreements []Disagreement) string {
+	var list []string
+	for _, d := range disagreements {
+		list = append(list, d.String())
+	}
+	return strings.Join(list, "; ")
+}
+
+// Fields compared by -crosscheck. The names of the runtime and the VM are
+// not, as the sources name them differently.
+var crossCheckedFields = map[string]bool{
+	"java_version":         true,
+	"java_runtime_version": true,
+	"java_runtime_vendor":  true,
+	"java_vm_vendor":       true,
+	"java_version_date":    true,
+	"vendor_version":       true,
+}
+
+// crossCheck returns the fields that the detectors give different values
+// for. Fields a detector has no value for are not compared.
+func crossCheck(detectors []Detector, results detectionResults) []Disagreement {
+	var disagreements []Disagreement
+	for i, field := range (&JVMVersionInfo{}).fields() {
+		if !crossCheckedFields[field.name] {
+			continue
+		}
+		values := make(map[string]string)
+		distinct := make(map[string]bool)
+		for _, d := range detectors {
+			info := results[d.Name()]
+			if info == nil {
+				continue
+			}
+			value := *info.fields()[i].value
+			if value == "" {
+				continue
+			}
+			values[d.Name()] = value
+			// 11.0.12 and 11.0.12.0 are the same
+			if field.name == "java_version" {
+				if v, e := ParseJavaVersion(value); e == nil {
+					value = v.Short()
+				}
+			}
+			distinct[value] = true
+		}
+		if len(distinct) > 1 {
+			disagreements = append(disagreements, Disagreement{Field: field.name, Values: values})
+		}
+	}
+	return disagreements
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Directories where JDKs are usually installed system-wide
+var quickSystemDirs = []string{
+	"/usr/lib/jvm",
+	"/usr/lib64/jvm",
+	"/usr/java",
+	"/usr/local/java",
+	"/opt",
+	"/usr/local",
+	"/snap",
+}
+
+// Directories (relative to home directories) used by SDK managers, build
+// tools and IDEs for the JDKs they download or bundle
+var quickHomeDirs = []string{
+	".sdkman/candidates/java",
+	".jdks",
+	".gradle/jdks",
+	".asdf/installs/java",
+	".jenv/versions",
+	".local/share/JetBrains",
+	".vscode/extensions",
+	".vscode-server/extensions",
+	".p2/pool/plugins",
+	"eclipse",
+	"android-studio",
+	".local/jdk",
+}
+
+// Enough to reach a libjvm of a JDK bundled two levels deep into an IDE
+// installation below one of the above directories
+const quickMaxDepth = 8
+
+// probeKnownLocations reports libjvm files of running JVMs, of the java
+// executables found on PATH and in /etc/alternatives, and the ones found in
+// well-known installation directories
+func (s *Scanner) probeKnownLocations(found func(libjvm string)) error {
+	var lock sync.Mutex
+	reported := make(map[string]bool)
+	report := func(libjvm string) {
+		if real, e := s.config.evalSymlinks(libjvm); e == nil {
+			libjvm = real
+		}
+		if !s.underRoots(libjvm) || !s.config.filter.Admits(libjvm, false) {
+			return
+		}
+		if m := s.mounts.MountOf(libjvm); m != nil && s.inScannedSnap(m) {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if !reported[libjvm] {
+			reported[libjvm] = true
+			found(libjvm)
+		}
+	}
+
+	for libjvm := range findInUseLibJVM(s.config.fileSystem()) {
+		report(s.config.hostPath(libjvm))
+	}
+
+	walker := NewWalker(s.config, s.quickPrune, report, s.reportError)
+	walker.maxDepth = quickMaxDepth
+	if s.config.maxdepth > 0 && s.config.maxdepth < quickMaxDepth {
+		walker.maxDepth = s.config.maxdepth
+	}
+	walker.budget = s.budget
+	walker.onDir = s.progress.visitDir
+
+	walked := make(map[string]bool)
+	walk := func(dir string) {
+		real, e := s.config.evalSymlinks(dir)
+		if e != nil || walked[real] || !s.underRoots(real) || !s.config.filter.Admits(real, true) || s.budget.Exhausted() {
+			return
+		}
+		walked[real] = true
+		if info, e := os.Stat(real); e == nil && info.IsDir() {
+			_ = walker.Walk(real, 0, nil)
+		}
+	}
+
+	for _, home := range javaHomesOfExecutables(s.config) {
+		walk(home)
+	}
+	for _, dir := range quickSystemDirs {
+		walk(s.config.hostPath(dir))
+	}
+	for _, home := range homeDirectories(s.config.sysroot) {
+		for _, dir := range quickHomeDirs {
+			p := path.Join(home, dir)
+			walk(p)
+			// Version managers keep symlinks to JDKs installed elsewhere
+			real, e := s.config.evalSymlinks(p)
+			if e != nil {
+				continue
+			}
+			if entries, e := ioutil.ReadDir(real); e == nil {
+				for _, entry := range entries {
+					if entry.Mode()&os.ModeSymlink != 0 {
+						walk(path.Join(p, entry.Name()))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// quickPrune keeps the quick scan away from skipped filesystems and paths,
+// and from the mounts of snap images, which are scanned on their own
+func (s *Scanner) quickPrune(p string, dir bool) bool {
+	if dir {
+		if m := s.mounts.Lookup(p); m != nil {
+			for _, skip := range s.config.skipmount {
+				if isSubPath(p, skip) {
+					return true
+				}
+			}
+			if fsTypeMatches(m.FSType, s.config.skipfs) || s.inScannedSnap(m) {
+				return true
+			}
+		}
+	}
+	return !s.config.filter.Admits(p, dir)
+}
+
+func (s *Scanner) underRoots(p string) bool {
+	for _, r := range s.config.roots {
+		if isSubPath(p, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// javaHomesOfExecutables returns the Java homes of the java executables
+// found in the PATH directories and of all the /etc/alternatives entries.
+// With -sysroot, the PATH of jdowser has nothing to do with the system.
+func javaHomesOfExecutables(config *Config) []string {
+	var executables []string
+	if config.sysroot == "" {
+		for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+			if dir != "" {
+				executables = append(executables, path.Join(dir, "java"))
+			}
+		}
+	}
+	alternatives := config.hostPath("/etc/alternatives")
+	if entries, e := ioutil.ReadDir(alternatives); e == nil {
+		for _, entry := range entries {
+			executables = append(executables, path.Join(alternatives, entry.Name()))
+		}
+	}
+
+	var homes []string
+	for _, executable := range executables {
+		real, e := config.evalSymlinks(executable)
+		if e != nil || path.Base(path.Dir(real)) != "bin" {
+			continue
+		}
+		// Only bin directories with a java launcher belong to a Java home
+		home := path.Dir(path.Dir(real))
+		if info, e := os.Lstat(path.Join(home, "bin/java")); e == nil && info.Mode().IsRegular() {
+			homes = append(homes, home)
+		}
+	}
+	return homes
+}
+
+// homeDirectories returns the home directories of the users from
+// /etc/passwd, of the current user, and the ones found in /home, of the
+// system mounted at sysroot if not empty
+func homeDirectories(sysroot string) []string {
+	var homes []string
+	if sysroot == "" {
+		if home, e := os.UserHomeDir(); e == nil {
+			homes = append(homes, home)
+		}
+	}
+	fsys := sysrootFS(sysroot)
+	if f, e := fsys.Open("/etc/passwd"); e == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) >= 6 && fields[5] != "" && fields[5] != "/" {
+				homes = append(homes, path.Join("/", sysroot, fields[5]))
+			}
+		}
+		_ = f.Close()
+	}
+	if entries, e := fsys.ReadDir("/home"); e == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				homes = append(homes, path.Join("/", sysroot, "/home", entry.Name()))
+			}
+		}
+	}
+	return homes
+}
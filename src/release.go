@@ -0,0 +1,70 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"path"
+	"strings"
+)
+
+// ReleaseInfo holds the fields of the release file found in the Java home
+// of JDK 9 and later, and of the images made by jlink
+type ReleaseInfo struct {
+	JavaVersion        string   `json:"java_version,omitempty"`
+	JavaVersionDate    string   `json:"java_version_date,omitempty"`
+	Implementor        string   `json:"implementor,omitempty"`
+	ImplementorVersion string   `json:"implementor_version,omitempty"`
+	JavaRuntimeVersion string   `json:"java_runtime_version,omitempty"`
+	Modules            []string `json:"modules,omitempty"`
+	OSArch             string   `json:"os_arch,omitempty"`
+	Source             string   `json:"source,omitempty"`
+}
+
+// readReleaseFile parses the release file of a Java home. It returns nil if
+// there is none, or if it has none of the known fields.
+func readReleaseFile(fsys FileSystem, javaHome string) *ReleaseInfo {
+	f, e := fsys.Open(path.Join(javaHome, "release"))
+	if e != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	var release ReleaseInfo
+	found := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		idx := strings.IndexByte(s.Text(), '=')
+		if idx < 0 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(s.Text()[idx+1:]), "\"")
+		switch strings.TrimSpace(s.Text()[:idx]) {
+		case "JAVA_VERSION":
+			release.JavaVersion = value
+		case "JAVA_VERSION_DATE":
+			release.JavaVersionDate = value
+		case "IMPLEMENTOR":
+			release.Implementor = value
+		case "IMPLEMENTOR_VERSION":
+			release.ImplementorVersion = value
+		case "JAVA_RUNTIME_VERSION":
+			release.JavaRuntimeVersion = value
+		case "MODULES":
+			release.Modules = strings.Fields(value)
+		case "OS_ARCH":
+			release.OSArch = value
+		case "SOURCE":
+			release.Source = value
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return &release
+}
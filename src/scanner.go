@@ -0,0 +1,487 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Scanner walks every mount point of the scan in its own goroutine and
+// hands found libjvm files to a pool of analysis workers. Results are
+// serialized by a single writer so that the output stays one JSON line
+// per installation.
+type Scanner struct {
+	config *Config
+	out    io.Writer
+	errOut io.Writer
+	mounts *MountTable
+	cache  *InstallationCache
+	// Layers of container images and containers, scanned on their own
+	containers *ContainerStorage
+	// Snap images, scanned instead of their mounts
+	snaps  *SnapImages
+	budget *Budget
+
+	// Where the libjvm files come from
+	source     func(found func(libjvm string)) error
+	sourceName string
+	walks      bool
+
+	errLock  sync.Mutex
+	coverage *Coverage
+	progress *progressTracker
+
+	// What has to be known to resume the scan
+	stateLock sync.Mutex
+	positions map[string]*WalkPosition
+	seen      map[string]bool // libjvm files reported or written already
+	inflight  map[string]bool // libjvm files not yet written
+	resumed   *Checkpoint
+}
+
+func NewScanner(config *Config, out io.Writer, errOut io.Writer) (*Scanner, error) {
+	mounts, e := ReadMountTable()
+	if e != nil {
+		if len(config.skipfs) > 0 {
+			return nil, fmt.Errorf("cannot honour -skipfs: %s", e.Error())
+		}
+		mounts = &MountTable{byPath: make(map[string]*Mount)}
+	}
+	s := &Scanner{
+		config:   config,
+		out:      out,
+		errOut:   errOut,
+		mounts:   mounts,
+		cache:    LoadInstallationCache(config),
+		budget:   NewBudget(config),
+		progress: newProgressTracker(),
+
+		positions: make(map[string]*WalkPosition),
+		seen:      make(map[string]bool),
+		inflight:  make(map[string]bool),
+	}
+	if config.sysroot == "" {
+		s.containers = LoadContainerStorage(mounts)
+	} else {
+		// The container runtimes of the host have nothing to do with the
+		// scanned system
+		s.containers = &ContainerStorage{mounts: mounts, layers: make(map[string]*ContainerLayer)}
+	}
+	s.snaps = LoadSnapImages(config)
+	s.coverage = s.planCoverage()
+
+	switch {
+	case config.quick:
+		s.source, s.sourceName = s.probeKnownLocations, "quick"
+	case config.index != INDEX_NONE:
+		if index, e := OpenIndexSource(config.index, config.libjvmFileName, config.sysroot); e == nil {
+			s.source, s.sourceName = func(found func(libjvm string)) error {
+				return s.checkIndexCandidates(index, found)
+			}, index.Name()
+		} else if _, ok := e.(*PlocateError); ok && config.index == INDEX_LOCATE {
+			// Walking instead would not be what was asked for, and
+			// updatedb would not fix it
+			return nil, e
+		} else {
+			// Fall back to a full walk
+			s.source, s.sourceName, s.walks = s.walkMounts, "walk ("+e.Error()+")", true
+		}
+	default:
+		s.source, s.sourceName, s.walks = s.walkMounts, "walk", true
+	}
+	return s, nil
+}
+
+// SourceName tells where the scan takes the libjvm files from
+func (s *Scanner) SourceName() string {
+	return s.sourceName
+}
+
+func (s *Scanner) reportError(e error) {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	if fe, ok := e.(*FormatError); ok {
+		s.coverage.skipFile(fe.Path, fe.Reason)
+		return
+	}
+	_, _ = fmt.Fprintln(s.errOut, e.Error())
+	s.progress.countError()
+	if we, ok := e.(*WalkError); ok && (we.Op == "open" || we.Op == "readdir") {
+		if m := s.mounts.MountOf(we.Path); m != nil {
+			s.coverage.countUnreadable(m.MountPoint)
+		} else {
+			s.coverage.countUnreadable("")
+		}
+	}
+}
+
+// Coverage returns a snapshot of the scan coverage. Quick and index based
+// scans do not walk mounts, and only have the files they skipped.
+func (s *Scanner) Coverage() *Coverage {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	skipped := append([]SkippedFile(nil), s.coverage.SkippedFiles...)
+	if !s.walks {
+		if len(skipped) == 0 {
+			return nil
+		}
+		return &Coverage{SkippedFiles: skipped}
+	}
+	c := *s.coverage
+	c.Mounts = append([]MountCoverage(nil), s.coverage.Mounts...)
+	c.SkippedFiles = skipped
+	return &c
+}
+
+// Progress returns a snapshot of the scan progress
+func (s *Scanner) Progress() *Progress {
+	return s.progress.snapshot()
+}
+
+// ExpectDirs sets the number of directories the scan is expected to visit,
+// which the ETA of the progress is based on
+func (s *Scanner) ExpectDirs(dirs int64) {
+	s.progress.expectedDirs = dirs
+}
+
+// Resume makes the scan continue from the given checkpoint, leaving out the
+// installations written to the report already. Pending files are analysed
+// again, as an archive may have been written in part.
+func (s *Scanner) Resume(cp *Checkpoint, written map[string]bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.resumed = cp
+	for source := range written {
+		s.seen[s.config.hostPath(source)] = true
+	}
+	for _, pending := range cp.Pending {
+		delete(s.seen, pending)
+	}
+	if cp.Coverage != nil {
+		unattributed := cp.Coverage.UnreadableDirs
+		for _, mc := range cp.Coverage.Mounts {
+			for i := 0; i < mc.UnreadableDirs; i++ {
+				s.coverage.countUnreadable(mc.MountPoint)
+			}
+			unattributed -= mc.UnreadableDirs
+		}
+		// Directories outside of the known mounts
+		for i := 0; i < unattributed; i++ {
+			s.coverage.countUnreadable("")
+		}
+		for _, sf := range cp.Coverage.SkippedFiles {
+			s.coverage.skipFile(sf.Path, sf.Reason)
+		}
+	}
+	if cp.Progress != nil {
+		s.progress.resume(cp.Progress)
+	}
+}
+
+// Checkpoint returns what is needed to resume the scan if it gets
+// interrupted now
+func (s *Scanner) Checkpoint() *Checkpoint {
+	cp := &Checkpoint{
+		ScanSet:  scanSetArgs(s.config.args),
+		Coverage: s.Coverage(),
+		Progress: s.Progress(),
+	}
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	// Positions go first: whatever a walk has got past is either inflight
+	// or written by now
+	for root, pos := range s.positions {
+		cp.Walks = append(cp.Walks, pos.checkpoint(root))
+	}
+	for libjvm := range s.inflight {
+		cp.Pending = append(cp.Pending, libjvm)
+	}
+	sort.Slice(cp.Walks, func(i, j int) bool {
+		return cp.Walks[i].Root < cp.Walks[j].Root
+	})
+	sort.Strings(cp.Pending)
+	return cp
+}
+
+// track remembers a libjvm file until its installation is written. It
+// returns false if the file has been reported already.
+func (s *Scanner) track(libjvm string) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if s.seen[libjvm] {
+		return false
+	}
+	s.seen[libjvm] = true
+	s.inflight[libjvm] = true
+	return true
+}
+
+func (s *Scanner) untrack(libjvm string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	delete(s.inflight, libjvm)
+}
+
+// position returns the position of the walk of the given walk root,
+// resumed from the checkpoint if there is one
+func (s *Scanner) position(root string) *WalkPosition {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	wc := WalkCheckpoint{Root: root}
+	if s.resumed != nil {
+		for _, resumed := range s.resumed.Walks {
+			if resumed.Root == root {
+				wc = resumed
+			}
+		}
+	}
+	pos := newWalkPosition(wc)
+	s.positions[root] = pos
+	return pos
+}
+
+// prune tells the walkers whether they should leave out the given path.
+// Mount points are either walked on their own or skipped altogether.
+func (s *Scanner) prune(p string, dir bool) bool {
+	if dir && s.mounts.Lookup(p) != nil {
+		return true
+	}
+	return !s.config.filter.Admits(p, dir)
+}
+
+// isSubPath reports whether p is equal to dir or located below it
+func isSubPath(p string, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// Run scans the mounts under the scan roots, or the well-known locations
+// or the index if requested
+func (s *Scanner) Run() error {
+	return s.run(s.source)
+}
+
+// walkMounts walks every mount of the scan in its own goroutine
+func (s *Scanner) walkMounts(found func(libjvm string)) error {
+	walker := NewWalker(s.config, s.prune, found, s.reportError)
+	walker.maxDepth = s.config.maxdepth
+	walker.budget = s.budget
+	walker.onDir = s.progress.visitDir
+
+	roots := s.walkRoots()
+	positions := make([]*WalkPosition, len(roots))
+	for i, root := range roots {
+		positions[i] = s.position(root)
+	}
+	errs := make([]error, len(roots))
+	slots := make(chan bool, s.config.workers)
+	var walkers sync.WaitGroup
+	for i, root := range roots {
+		walkers.Add(1)
+		slots <- true
+		go func(i int, root string) {
+			defer walkers.Done()
+			errs[i] = walker.Walk(root, s.depthOf(root), positions[i])
+			<-slots
+		}(i, root)
+	}
+	walkers.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// analysis holds the installations found through one candidate file
+type analysis struct {
+	candidate     string
+	installations []*JVMInstallation
+}
+
+// run analyses the snap images and the libjvm files (and archives)
+// reported by source. The source may report files from several goroutines
+// and is never blocked by the analysis.
+func (s *Scanner) run(source func(found func(libjvm string)) error) error {
+	candidates, queued := newPathQueue()
+	results := make(chan analysis, s.config.workers)
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.config.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for libjvm := range queued {
+				if s.budget.Exhausted() {
+					// Drain the queue
+					continue
+				}
+				if installations := s.analyse(libjvm); len(installations) > 0 {
+					results <- analysis{libjvm, installations}
+				} else {
+					s.untrack(libjvm)
+				}
+			}
+		}()
+	}
+
+	written := make(chan bool)
+	go func() {
+		for result := range results {
+			for _, info := range result.installations {
+				if txt, _ := json.Marshal(info); txt != nil {
+					_, _ = fmt.Fprintln(s.out, string(txt))
+					s.progress.countFound()
+				}
+			}
+			s.untrack(result.candidate)
+		}
+		written <- true
+	}()
+
+	found := func(libjvm string) {
+		if s.track(libjvm) {
+			candidates <- libjvm
+		}
+	}
+	// Installations the interrupted scan had found but not written
+	if s.resumed != nil {
+		for _, libjvm := range s.resumed.Pending {
+			found(libjvm)
+		}
+	}
+	for _, snap := range s.snaps.images {
+		if s.scansSnap(snap) {
+			found(snap.File)
+		}
+	}
+	e := source(found)
+
+	close(candidates)
+	workers.Wait()
+	close(results)
+	<-written
+	s.progress.setCurrentPath("")
+
+	if e != nil {
+		return e
+	}
+	// A partial or resumed scan keeps the cached installations it has not
+	// analysed itself
+	budgetErr := s.budget.Err()
+	if e := s.cache.Save(budgetErr != nil || s.resumed != nil); e != nil {
+		s.reportError(e)
+	}
+	return budgetErr
+}
+
+// scansSnap tells whether the given snap image is within the scan
+func (s *Scanner) scansSnap(snap *SnapImage) bool {
+	return s.underRoots(snap.File) && s.config.filter.Admits(snap.File, false)
+}
+
+// inScannedSnap reports whether m is mounted from a snap image that the
+// scan reads on its own
+func (s *Scanner) inScannedSnap(m *Mount) bool {
+	snap := s.snaps.MountedAt(m)
+	return snap != nil && s.scansSnap(snap)
+}
+
+// analyse returns the installation the given libjvm belongs to, or the
+// installations held by the given archive or snap image. Unchanged
+// installations are taken from the cache without running anything.
+func (s *Scanner) analyse(candidate string) []*JVMInstallation {
+	config := s.config
+	packed := isArchive(path.Base(candidate)) || s.snaps.Lookup(candidate) != nil
+	if (s.containers.LayerOf(candidate) != nil || packed) && !config.nojvmrun {
+		// The java of a layer may need libraries of other layers, and
+		// nothing gets run from archives or snap images
+		noRun := *config
+		noRun.nojvmrun = true
+		config = &noRun
+	}
+	key, keyErr := NewCacheKey(candidate, config)
+	var installations []*JVMInstallation
+	cached := false
+	if keyErr == nil {
+		installations, cached = s.cache.Lookup(key)
+	}
+	if !cached {
+		var e error
+		if installations, e = s.inspect(candidate, config); e != nil {
+			s.reportError(e)
+			return nil
+		}
+	}
+	for _, info := range installations {
+		s.containers.Attribute(info)
+	}
+	if keyErr == nil {
+		s.cache.Store(key, installations...)
+	}
+	return installations
+}
+
+// inspect analyses a libjvm, an archive or a snap image. With -sysroot,
+// the installations are reported with their paths in the scanned system.
+func (s *Scanner) inspect(candidate string, config *Config) ([]*JVMInstallation, error) {
+	var installations []*JVMInstallation
+	var e error
+	if snap := s.snaps.Lookup(candidate); snap != nil {
+		installations, e = ScanSnap(snap, config)
+	} else if isArchive(path.Base(candidate)) {
+		installations, e = ScanArchive(candidate, config)
+	} else {
+		installations = []*JVMInstallation{InitJVMInstallation(config.fileSystem(), config.systemPath(candidate), config)}
+	}
+	if config.sysroot != "" {
+		for _, inst := range installations {
+			if inst.ArchivePath != "" {
+				inst.ArchivePath = config.systemPath(inst.ArchivePath)
+			}
+			inst.Sysroot = config.sysroot
+		}
+	}
+	return installations, e
+}
+
+// newPathQueue returns a pair of channels connected by an unbounded buffer,
+// so sending to the first one never blocks on slow receivers of the second.
+// Closing the input closes the output once the buffer is drained.
+func newPathQueue() (chan<- string, <-chan string) {
+	in := make(chan string)
+	out := make(chan string)
+	go func() {
+		var buffer []string
+		src := in
+		for src != nil || len(buffer) > 0 {
+			var send chan string
+			var next string
+			if len(buffer) > 0 {
+				send = out
+				next = buffer[0]
+			}
+			select {
+			case p, ok := <-src:
+				if !ok {
+					src = nil
+					continue
+				}
+				buffer = append(buffer, p)
+			case send <- next:
+				buffer = buffer[1:]
+			}
+		}
+		close(out)
+	}()
+	return in, out
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func newResumedScanner(cp *Checkpoint) *Scanner {
+	s := &Scanner{
+		config:    &Config{},
+		coverage:  &Coverage{Mounts: []MountCoverage{{MountPoint: "/", Scanned: true}}},
+		progress:  newProgressTracker(),
+		walks:     true,
+		positions: make(map[string]*WalkPosition),
+		seen:      make(map[string]bool),
+		inflight:  make(map[string]bool),
+	}
+	s.Resume(cp, nil)
+	return s
+}
+
+func TestResumeCarriesUnreadableDirs(t *testing.T) {
+	s := newResumedScanner(&Checkpoint{Coverage: &Coverage{
+		Mounts:         []MountCoverage{{MountPoint: "/", Scanned: true, UnreadableDirs: 1}},
+		UnreadableDirs: 3,
+	}})
+	c := s.Coverage()
+	if c.UnreadableDirs != 3 || c.Mounts[0].UnreadableDirs != 1 {
+		t.Errorf("got %d unreadable directories, %d in /", c.UnreadableDirs, c.Mounts[0].UnreadableDirs)
+	}
+}
+
+func TestResumeCarriesProgress(t *testing.T) {
+	s := newResumedScanner(&Checkpoint{Progress: &Progress{DirsVisited: 10, Found: 2, Errors: 3, Elapsed: 60}})
+	s.progress.visitDir("/opt")
+	p := s.Checkpoint().Progress
+	if p.DirsVisited != 11 || p.Found != 2 || p.Errors != 3 || p.Elapsed < 60 {
+		t.Errorf("got %+v", p)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Where snapd keeps the squashfs images of the installed snaps
+const snapImagesDir = "/var/lib/snapd/snaps"
+
+// Where snapd mounts the images, depending on the distribution
+var snapMountDirs = []string{"/snap", "/var/lib/snapd/snap"}
+
+// SnapImage is the image of one revision of an installed snap
+type SnapImage struct {
+	Name     string
+	Revision string
+	File     string
+}
+
+// SnapImages lists the snap images of the scanned system. The images are
+// scanned instead of the filesystems mounted from them, which -onefs skips.
+type SnapImages struct {
+	images    []*SnapImage
+	byFile    map[string]*SnapImage
+	dir       string
+	mountDirs []string
+}
+
+func LoadSnapImages(config *Config) *SnapImages {
+	si := &SnapImages{byFile: make(map[string]*SnapImage), dir: config.hostPath(snapImagesDir)}
+	if real, e := config.evalSymlinks(si.dir); e == nil {
+		si.dir = real
+	}
+	for _, dir := range snapMountDirs {
+		si.mountDirs = append(si.mountDirs, config.hostPath(dir))
+	}
+	entries, e := ioutil.ReadDir(si.dir)
+	if e != nil {
+		return si
+	}
+	for _, entry := range entries {
+		// <name>_<revision>.snap, where the name may hold an instance key
+		// after another underscore
+		base := strings.TrimSuffix(entry.Name(), ".snap")
+		sep := strings.LastIndex(base, "_")
+		if !entry.Mode().IsRegular() || base == entry.Name() || sep <= 0 {
+			continue
+		}
+		snap := &SnapImage{
+			Name:     base[:sep],
+			Revision: base[sep+1:],
+			File:     path.Join(si.dir, entry.Name()),
+		}
+		si.images = append(si.images, snap)
+		si.byFile[snap.File] = snap
+	}
+	return si
+}
+
+// Lookup returns the snap image of the given file, or nil
+func (si *SnapImages) Lookup(file string) *SnapImage {
+	return si.byFile[file]
+}
+
+// MountedAt returns the snap image mounted at m, or nil
+func (si *SnapImages) MountedAt(m *Mount) *SnapImage {
+	if m.FSType != "squashfs" {
+		return nil
+	}
+	for _, dir := range si.mountDirs {
+		if !isSubPath(m.MountPoint, dir) {
+			continue
+		}
+		parts := splitPath(strings.TrimPrefix(m.MountPoint, dir))
+		if len(parts) == 2 {
+			return si.byFile[path.Join(si.dir, parts[0]+"_"+parts[1]+".snap")]
+		}
+	}
+	return nil
+}
+
+// ScanSnap finds the installations held in a snap image without mounting
+// it or running anything
+func ScanSnap(snap *SnapImage, config *Config) ([]*JVMInstallation, error) {
+	fsys, e := OpenSquashFS(snap.File)
+	if e != nil {
+		return nil, e
+	}
+	defer fsys.Close()
+
+	noRun := *config
+	noRun.nojvmrun = true
+	installations := findInstallations(fsys, &noRun, config.libjvmFileName)
+	for _, inst := range installations {
+		inst.Kind = KIND_SNAP
+		inst.ArchivePath = snap.File
+		inst.Snap = snap.Name
+		inst.SnapRevision = snap.Revision
+	}
+	return installations, nil
+}
@@ -0,0 +1,681 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The squashfs (version 4) format
+const (
+	squashfsMagic           = 0x73717368
+	squashfsMetadataSize    = 8192
+	squashfsMetadataRaw     = 0x8000 // in the headers of metadata blocks
+	squashfsDataRaw         = 1 << 24
+	squashfsNoFragment      = 0xffffffff
+	squashfsFragmentsPerRef = squashfsMetadataSize / 16
+)
+
+// Compressors of squashfs images. Only gzip and xz ones can be read.
+const (
+	squashfsGzip = 1
+	squashfsLZMA = 2
+	squashfsLZO  = 3
+	squashfsXZ   = 4
+	squashfsLZ4  = 5
+	squashfsZstd = 6
+)
+
+var squashfsCompressorNames = map[uint16]string{
+	squashfsLZMA: "lzma",
+	squashfsLZO:  "lzo",
+	squashfsLZ4:  "lz4",
+	squashfsZstd: "zstd",
+}
+
+// Types of squashfs inodes
+const (
+	squashfsDir = iota + 1
+	squashfsFile
+	squashfsSymlink
+	squashfsBlockDev
+	squashfsCharDev
+	squashfsFifo
+	squashfsSocket
+	squashfsExtDir
+	squashfsExtFile
+	squashfsExtSymlink
+	squashfsExtBlockDev
+	squashfsExtCharDev
+	squashfsExtFifo
+	squashfsExtSocket
+)
+
+var errCorruptedSquashfs = errors.New("corrupted squashfs image")
+
+type squashfsSuperblock struct {
+	Magic               uint32
+	InodeCount          uint32
+	ModTime             uint32
+	BlockSize           uint32
+	FragmentCount       uint32
+	Compressor          uint16
+	BlockLog            uint16
+	Flags               uint16
+	IDCount             uint16
+	VersionMajor        uint16
+	VersionMinor        uint16
+	RootInode           uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	ExportTableStart    uint64
+}
+
+// squashFS reads a squashfs image in place, as snaps are. Directories and
+// files are only read when looked up. A squashFS is not safe for concurrent
+// use.
+type squashFS struct {
+	f    *os.File
+	sb   squashfsSuperblock
+	root *squashInode
+	// Decompressed metadata blocks by position
+	metadata map[int64]*squashfsMetadata
+	// The last fragment block read, which small files share
+	fragment     uint32
+	fragmentData []byte
+}
+
+type squashfsMetadata struct {
+	data []byte
+	next int64 // position of the following block
+}
+
+// squashInode is a file of a squashFS. It is its own os.FileInfo.
+type squashInode struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	target  string // of symbolic links
+
+	// Directories: where the listing is in the directory table
+	dirBlock  uint32
+	dirOffset uint16
+	children  map[string]*squashInode
+	listed    bool
+
+	// Regular files: where the data blocks are, and the fragment holding
+	// the tail of the file if any
+	blocksStart    int64
+	blockSizes     []uint32
+	fragment       uint32
+	fragmentOffset uint32
+}
+
+func (n *squashInode) Name() string       { return n.name }
+func (n *squashInode) Size() int64        { return n.size }
+func (n *squashInode) Mode() os.FileMode  { return n.mode }
+func (n *squashInode) ModTime() time.Time { return n.modTime }
+func (n *squashInode) IsDir() bool        { return n.mode.IsDir() }
+func (n *squashInode) Sys() interface{}   { return nil }
+
+func OpenSquashFS(filePath string) (*squashFS, error) {
+	f, e := os.Open(filePath)
+	if e != nil {
+		return nil, e
+	}
+	fsys := &squashFS{
+		f:        f,
+		metadata: make(map[int64]*squashfsMetadata),
+		fragment: squashfsNoFragment,
+	}
+	if e = fsys.init(); e != nil {
+		closeFile(f)
+		if fe, ok := e.(*FormatError); ok {
+			fe.Path = filePath
+			return nil, fe
+		}
+		return nil, fmt.Errorf("%s: %s", filePath, e.Error())
+	}
+	return fsys, nil
+}
+
+func (fsys *squashFS) init() error {
+	if e := binary.Read(io.NewSectionReader(fsys.f, 0, 96), binary.LittleEndian, &fsys.sb); e != nil {
+		return errCorruptedSquashfs
+	}
+	sb := &fsys.sb
+	if sb.Magic != squashfsMagic || sb.VersionMajor != 4 {
+		return errors.New("not a squashfs 4 image")
+	}
+	if sb.BlockSize == 0 || sb.BlockSize > 1<<20 || sb.BlockSize != 1<<sb.BlockLog {
+		return errCorruptedSquashfs
+	}
+	if sb.Compressor != squashfsGzip && sb.Compressor != squashfsXZ {
+		name, ok := squashfsCompressorNames[sb.Compressor]
+		if !ok {
+			return fmt.Errorf("unknown squashfs compressor %d", sb.Compressor)
+		}
+		return &FormatError{Reason: name + " compressed squashfs is not supported"}
+	}
+	root, e := fsys.readInode(sb.RootInode, "/")
+	if e != nil {
+		return e
+	}
+	if !root.IsDir() {
+		return errCorruptedSquashfs
+	}
+	fsys.root = root
+	return nil
+}
+
+func (fsys *squashFS) Close() {
+	closeFile(fsys.f)
+}
+
+// decompress decompresses a block into at most max bytes
+func (fsys *squashFS) decompress(data []byte, max int) ([]byte, error) {
+	var r io.Reader
+	var e error
+	if fsys.sb.Compressor == squashfsGzip {
+		r, e = zlib.NewReader(bytes.NewReader(data))
+	} else {
+		r, e = newXZReader(bytes.NewReader(data))
+	}
+	if e != nil {
+		return nil, errCorruptedSquashfs
+	}
+	out, e := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if e != nil || len(out) > max {
+		return nil, errCorruptedSquashfs
+	}
+	return out, nil
+}
+
+// readAt reads size bytes at the given position of the image
+func (fsys *squashFS) readAt(pos int64, size int) ([]byte, error) {
+	if pos < 0 || size < 0 || pos+int64(size) > int64(fsys.sb.BytesUsed) {
+		return nil, errCorruptedSquashfs
+	}
+	data := make([]byte, size)
+	if _, e := fsys.f.ReadAt(data, pos); e != nil {
+		if e == io.EOF {
+			e = errCorruptedSquashfs
+		}
+		return nil, e
+	}
+	return data, nil
+}
+
+// metadataBlock returns the metadata block at the given position
+func (fsys *squashFS) metadataBlock(pos int64) (*squashfsMetadata, error) {
+	if block, ok := fsys.metadata[pos]; ok {
+		return block, nil
+	}
+	h, e := fsys.readAt(pos, 2)
+	if e != nil {
+		return nil, e
+	}
+	header := binary.LittleEndian.Uint16(h)
+	size := int(header &^ squashfsMetadataRaw)
+	if size == 0 || size > squashfsMetadataSize {
+		return nil, errCorruptedSquashfs
+	}
+	data, e := fsys.readAt(pos+2, size)
+	if e != nil {
+		return nil, e
+	}
+	if header&squashfsMetadataRaw == 0 {
+		if data, e = fsys.decompress(data, squashfsMetadataSize); e != nil {
+			return nil, e
+		}
+	}
+	block := &squashfsMetadata{data: data, next: pos + 2 + int64(size)}
+	fsys.metadata[pos] = block
+	return block, nil
+}
+
+// squashfsMetaReader reads metadata that may go on from one block to the
+// next
+type squashfsMetaReader struct {
+	fsys *squashFS
+	data []byte
+	next int64
+}
+
+func (fsys *squashFS) metaReader(pos int64, offset int) (*squashfsMetaReader, error) {
+	block, e := fsys.metadataBlock(pos)
+	if e != nil {
+		return nil, e
+	}
+	if offset > len(block.data) {
+		return nil, errCorruptedSquashfs
+	}
+	return &squashfsMetaReader{fsys: fsys, data: block.data[offset:], next: block.next}, nil
+}
+
+func (m *squashfsMetaReader) Read(p []byte) (int, error) {
+	for len(m.data) == 0 {
+		block, e := m.fsys.metadataBlock(m.next)
+		if e != nil {
+			return 0, e
+		}
+		m.data, m.next = block.data, block.next
+	}
+	n := copy(p, m.data)
+	m.data = m.data[n:]
+	return n, nil
+}
+
+// readInode reads the inode of the given reference, which is made of the
+// position of its metadata block in the inode table and of its offset
+// in that block
+func (fsys *squashFS) readInode(ref uint64, name string) (*squashInode, error) {
+	m, e := fsys.metaReader(int64(fsys.sb.InodeTableStart)+int64(ref>>16), int(ref&0xffff))
+	if e != nil {
+		return nil, e
+	}
+	read := func(v interface{}) error {
+		if e := binary.Read(m, binary.LittleEndian, v); e != nil {
+			return errCorruptedSquashfs
+		}
+		return nil
+	}
+	var h struct {
+		Type, Mode, UID, GID uint16
+		ModTime, Number      uint32
+	}
+	if e = read(&h); e != nil {
+		return nil, e
+	}
+	n := &squashInode{
+		name:    name,
+		mode:    os.FileMode(h.Mode & 0777),
+		modTime: time.Unix(int64(h.ModTime), 0),
+	}
+
+	switch h.Type {
+	case squashfsDir:
+		var d struct {
+			Block, Links uint32
+			Size, Offset uint16
+			Parent       uint32
+		}
+		if e = read(&d); e != nil {
+			return nil, e
+		}
+		n.mode |= os.ModeDir
+		n.dirBlock, n.dirOffset, n.size = d.Block, d.Offset, int64(d.Size)
+	case squashfsExtDir:
+		var d struct {
+			Links, Size, Block, Parent uint32
+			Indexes, Offset            uint16
+			Xattr                      uint32
+		}
+		if e = read(&d); e != nil {
+			return nil, e
+		}
+		n.mode |= os.ModeDir
+		n.dirBlock, n.dirOffset, n.size = d.Block, d.Offset, int64(d.Size)
+	case squashfsFile:
+		var f struct {
+			Start, Fragment, Offset, Size uint32
+		}
+		if e = read(&f); e != nil {
+			return nil, e
+		}
+		n.blocksStart, n.fragment, n.fragmentOffset, n.size = int64(f.Start), f.Fragment, f.Offset, int64(f.Size)
+	case squashfsExtFile:
+		var f struct {
+			Start, Size, Sparse            uint64
+			Links, Fragment, Offset, Xattr uint32
+		}
+		if e = read(&f); e != nil {
+			return nil, e
+		}
+		n.blocksStart, n.fragment, n.fragmentOffset, n.size = int64(f.Start), f.Fragment, f.Offset, int64(f.Size)
+	case squashfsSymlink, squashfsExtSymlink:
+		var s struct {
+			Links, Size uint32
+		}
+		if e = read(&s); e != nil {
+			return nil, e
+		}
+		if s.Size > 4096 {
+			return nil, errCorruptedSquashfs
+		}
+		target := make([]byte, s.Size)
+		if e = read(target); e != nil {
+			return nil, e
+		}
+		n.mode |= os.ModeSymlink
+		n.target, n.size = string(target), int64(s.Size)
+	case squashfsBlockDev, squashfsExtBlockDev:
+		n.mode |= os.ModeDevice
+	case squashfsCharDev, squashfsExtCharDev:
+		n.mode |= os.ModeDevice | os.ModeCharDevice
+	case squashfsFifo, squashfsExtFifo:
+		n.mode |= os.ModeNamedPipe
+	case squashfsSocket, squashfsExtSocket:
+		n.mode |= os.ModeSocket
+	default:
+		return nil, errCorruptedSquashfs
+	}
+
+	if n.mode.IsRegular() {
+		if n.size < 0 {
+			return nil, errCorruptedSquashfs
+		}
+		blocks := n.size >> fsys.sb.BlockLog
+		if n.fragment == squashfsNoFragment && n.size%int64(fsys.sb.BlockSize) != 0 {
+			blocks++
+		}
+		if blocks > 1<<24 {
+			return nil, errCorruptedSquashfs
+		}
+		n.blockSizes = make([]uint32, blocks)
+		if e = read(n.blockSizes); e != nil {
+			return nil, e
+		}
+	}
+	return n, nil
+}
+
+// readDir reads the listing of a directory once
+func (fsys *squashFS) readDir(n *squashInode) error {
+	if n.listed {
+		return nil
+	}
+	children := make(map[string]*squashInode)
+	// The size of a listing counts three bytes more
+	if n.size > 3 {
+		m, e := fsys.metaReader(int64(fsys.sb.DirectoryTableStart)+int64(n.dirBlock), int(n.dirOffset))
+		if e != nil {
+			return e
+		}
+		r := io.LimitReader(m, n.size-3)
+		for {
+			var h struct {
+				Count, Start, Number uint32
+			}
+			if e = binary.Read(r, binary.LittleEndian, &h); e == io.EOF {
+				break
+			} else if e != nil || h.Count >= 256 {
+				return errCorruptedSquashfs
+			}
+			for i := uint32(0); i <= h.Count; i++ {
+				var entry struct {
+					Offset      uint16
+					NumberDelta int16
+					Type        uint16
+					NameSize    uint16
+				}
+				if e = binary.Read(r, binary.LittleEndian, &entry); e != nil || entry.NameSize >= 256 {
+					return errCorruptedSquashfs
+				}
+				name := make([]byte, entry.NameSize+1)
+				if _, e = io.ReadFull(r, name); e != nil {
+					return errCorruptedSquashfs
+				}
+				if !validEntryName(string(name)) {
+					continue
+				}
+				child, e := fsys.readInode(uint64(h.Start)<<16|uint64(entry.Offset), string(name))
+				if e != nil {
+					return e
+				}
+				children[child.name] = child
+			}
+		}
+	}
+	n.children, n.listed = children, true
+	return nil
+}
+
+// validEntryName tells whether name may be the name of a directory entry
+func validEntryName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// lookup finds the file of the given path. Symbolic links are resolved
+// inside the image, and the last one only if follow is set.
+func (fsys *squashFS) lookup(name string, follow bool) (*squashInode, error) {
+	parts := splitPath(name)
+	stack := []*squashInode{fsys.root}
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		n := stack[len(stack)-1]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if !n.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		if e := fsys.readDir(n); e != nil {
+			return nil, e
+		}
+		child := n.children[part]
+		if child == nil {
+			return nil, os.ErrNotExist
+		}
+		if child.mode&os.ModeSymlink != 0 && (follow || len(parts) > 0) {
+			if links++; links > maxSymlinks {
+				return nil, syscall.ELOOP
+			}
+			if path.IsAbs(child.target) {
+				stack = stack[:1]
+			}
+			parts = append(splitPath(child.target), parts...)
+			continue
+		}
+		stack = append(stack, child)
+	}
+	return stack[len(stack)-1], nil
+}
+
+func (fsys *squashFS) node(op string, name string, follow bool) (*squashInode, error) {
+	n, e := fsys.lookup(name, follow)
+	if e != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: e}
+	}
+	return n, nil
+}
+
+func (fsys *squashFS) Stat(name string) (os.FileInfo, error) {
+	return fsys.node("stat", name, true)
+}
+
+func (fsys *squashFS) Lstat(name string) (os.FileInfo, error) {
+	return fsys.node("lstat", name, false)
+}
+
+func (fsys *squashFS) ReadDir(name string) ([]os.FileInfo, error) {
+	n, e := fsys.node("readdir", name, true)
+	if e != nil {
+		return nil, e
+	}
+	if !n.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if e = fsys.readDir(n); e != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: e}
+	}
+	entries := make([]os.FileInfo, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, child)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (fsys *squashFS) Open(name string) (File, error) {
+	n, e := fsys.node("open", name, true)
+	if e != nil {
+		return nil, e
+	}
+	if !n.mode.IsRegular() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	data := &squashfsData{fsys: fsys, node: n, block: -1}
+	offset := n.blocksStart
+	for _, size := range n.blockSizes {
+		data.offsets = append(data.offsets, offset)
+		offset += int64(size &^ squashfsDataRaw)
+	}
+	return &squashFile{io.NewSectionReader(data, 0, n.size), n}, nil
+}
+
+// squashfsData reads the contents of a file block by block
+type squashfsData struct {
+	fsys    *squashFS
+	node    *squashInode
+	offsets []int64 // of the data blocks
+	// The last block read
+	block int64
+	data  []byte
+}
+
+func (d *squashfsData) ReadAt(p []byte, off int64) (int, error) {
+	blockSize := int64(d.fsys.sb.BlockSize)
+	read := 0
+	for len(p) > 0 {
+		if off >= d.node.size {
+			return read, io.EOF
+		}
+		i := off / blockSize
+		if i != d.block {
+			data, e := d.readBlock(i)
+			if e != nil {
+				return read, e
+			}
+			d.block, d.data = i, data
+		}
+		start := int(off - i*blockSize)
+		if start >= len(d.data) {
+			return read, errCorruptedSquashfs
+		}
+		n := copy(p, d.data[start:])
+		p = p[n:]
+		off += int64(n)
+		read += n
+	}
+	return read, nil
+}
+
+// readBlock returns the data of the block with the given index, which is
+// in the fragment of the file if it is past its data blocks
+func (d *squashfsData) readBlock(i int64) ([]byte, error) {
+	blockSize := int64(d.fsys.sb.BlockSize)
+	length := d.node.size - i*blockSize
+	if length > blockSize {
+		length = blockSize
+	}
+	if i >= int64(len(d.offsets)) {
+		frag, e := d.fsys.readFragment(d.node.fragment)
+		if e != nil {
+			return nil, e
+		}
+		end := int64(d.node.fragmentOffset) + length
+		if end > int64(len(frag)) {
+			return nil, errCorruptedSquashfs
+		}
+		return frag[d.node.fragmentOffset:end], nil
+	}
+
+	size := d.node.blockSizes[i]
+	if size == 0 {
+		// A sparse block
+		return make([]byte, length), nil
+	}
+	data, e := d.fsys.readAt(d.offsets[i], int(size&^squashfsDataRaw))
+	if e != nil {
+		return nil, e
+	}
+	if size&squashfsDataRaw == 0 {
+		if data, e = d.fsys.decompress(data, int(blockSize)); e != nil {
+			return nil, e
+		}
+	}
+	if int64(len(data)) != length {
+		return nil, errCorruptedSquashfs
+	}
+	return data, nil
+}
+
+// readFragment returns the decompressed fragment block of the given index
+func (fsys *squashFS) readFragment(index uint32) ([]byte, error) {
+	if index == fsys.fragment {
+		return fsys.fragmentData, nil
+	}
+	if index >= fsys.sb.FragmentCount {
+		return nil, errCorruptedSquashfs
+	}
+	ref, e := fsys.readAt(int64(fsys.sb.FragmentTableStart)+8*int64(index/squashfsFragmentsPerRef), 8)
+	if e != nil {
+		return nil, e
+	}
+	m, e := fsys.metaReader(int64(binary.LittleEndian.Uint64(ref)), int(index%squashfsFragmentsPerRef)*16)
+	if e != nil {
+		return nil, e
+	}
+	var entry struct {
+		Start        uint64
+		Size, Unused uint32
+	}
+	if e = binary.Read(m, binary.LittleEndian, &entry); e != nil {
+		return nil, errCorruptedSquashfs
+	}
+	data, e := fsys.readAt(int64(entry.Start), int(entry.Size&^squashfsDataRaw))
+	if e != nil {
+		return nil, e
+	}
+	if entry.Size&squashfsDataRaw == 0 {
+		if data, e = fsys.decompress(data, int(fsys.sb.BlockSize)); e != nil {
+			return nil, e
+		}
+	}
+	fsys.fragment, fsys.fragmentData = index, data
+	return data, nil
+}
+
+// squashFile is an open file of a squashFS
+type squashFile struct {
+	*io.SectionReader
+	node *squashInode
+}
+
+func (f *squashFile) Close() error {
+	return nil
+}
+
+func (f *squashFile) Stat() (os.FileInfo, error) {
+	return f.node, nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// testdata/jdk_1.snap is a gzip compressed image with 4 KiB blocks, laid
+// out the way mksquashfs lays it out:
+//
+//	/meta/snap.yaml                              in a fragment
+//	/usr/lib/jvm/default-java -> temurin-17
+//	/usr/lib/jvm/temurin-17/release              in a fragment
+//	/usr/lib/jvm/temurin-17/bin/java             in a fragment
+//	/usr/lib/jvm/temurin-17/lib/server/libjvm.so an extended inode, with an
+//	                                             uncompressed block, a sparse
+//	                                             block, a compressed block
+//	                                             and a tail in a fragment
+//	/usr/share/doc/file000..file299              empty files, whose inodes
+//	                                             span two metadata blocks
+//
+// The root is an extended directory inode.
+const (
+	snapFixture          = "testdata/jdk_1.snap"
+	snapFixtureLibJVM    = "/usr/lib/jvm/temurin-17/lib/server/libjvm.so"
+	snapFixtureLibJVMSum = "72313580648881cb26a4e81fa39eea20a5a46cca70a3b1b6640fd33011da69c3"
+)
+
+func openSnapFixture(t *testing.T) *squashFS {
+	fsys, e := OpenSquashFS(snapFixture)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return fsys
+}
+
+func readSquashFile(t *testing.T, fsys *squashFS, name string) []byte {
+	f, e := fsys.Open(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = f.Close() }()
+	data, e := ioutil.ReadAll(f)
+	if e != nil {
+		t.Fatalf("%s: %v", name, e)
+	}
+	return data
+}
+
+// writeSquashfsSuperblock writes an image holding nothing but a superblock
+func writeSquashfsSuperblock(t *testing.T, dir string, compressor uint16) string {
+	sb := squashfsSuperblock{
+		Magic:        squashfsMagic,
+		BlockSize:    1 << 17,
+		BlockLog:     17,
+		Compressor:   compressor,
+		VersionMajor: 4,
+		BytesUsed:    96,
+	}
+	var buf bytes.Buffer
+	if e := binary.Write(&buf, binary.LittleEndian, &sb); e != nil {
+		t.Fatal(e)
+	}
+	p := path.Join(dir, "jdk_1.snap")
+	if e := ioutil.WriteFile(p, buf.Bytes(), 0644); e != nil {
+		t.Fatal(e)
+	}
+	return p
+}
+
+func tempDir(t *testing.T) string {
+	dir, e := ioutil.TempDir("", "jdowser")
+	if e != nil {
+		t.Fatal(e)
+	}
+	return dir
+}
+
+func TestOpenSquashFSUnsupportedCompressor(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for compressor, name := range squashfsCompressorNames {
+		p := writeSquashfsSuperblock(t, dir, compressor)
+		_, e := OpenSquashFS(p)
+		fe, ok := e.(*FormatError)
+		if !ok {
+			t.Errorf("%s: got error %v, want a FormatError", name, e)
+			continue
+		}
+		if fe.Path != p || !strings.HasPrefix(fe.Reason, name+" ") {
+			t.Errorf("%s: got %q for %s", name, fe.Reason, fe.Path)
+		}
+	}
+}
+
+func TestOpenSquashFSUnknownCompressor(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	p := writeSquashfsSuperblock(t, dir, 42)
+	_, e := OpenSquashFS(p)
+	if _, ok := e.(*FormatError); ok || e == nil {
+		t.Errorf("got error %v, want a read error", e)
+	}
+}
+
+func TestSquashFSRead(t *testing.T) {
+	fsys := openSnapFixture(t)
+	defer fsys.Close()
+
+	libjvm := readSquashFile(t, fsys, snapFixtureLibJVM)
+	sum := sha256.Sum256(libjvm)
+	if len(libjvm) != 13288 || hex.EncodeToString(sum[:]) != snapFixtureLibJVMSum {
+		t.Errorf("got %d bytes of libjvm, with sha256 %x", len(libjvm), sum)
+	}
+	// The sparse block
+	if !bytes.Equal(libjvm[4096:8192], make([]byte, 4096)) {
+		t.Error("got data in the sparse block")
+	}
+	if data := readSquashFile(t, fsys, "/meta/snap.yaml"); string(data) != "name: jdk\nversion: 17.0.4.1\n" {
+		t.Errorf("got snap.yaml %q", data)
+	}
+	if data := readSquashFile(t, fsys, "/usr/lib/jvm/default-java/release"); !strings.Contains(string(data), `JAVA_VERSION="17.0.4.1"`) {
+		t.Errorf("got release %q", data)
+	}
+
+	// Reading at an offset across blocks
+	f, e := fsys.Open(snapFixtureLibJVM)
+	if e != nil {
+		t.Fatal(e)
+	}
+	p := make([]byte, 300)
+	if n, e := f.ReadAt(p, 12200); n != len(p) || e != nil || !bytes.Equal(p, libjvm[12200:12500]) {
+		t.Errorf("got %d bytes at 12200, %v", n, e)
+	}
+}
+
+func TestSquashFSLookup(t *testing.T) {
+	fsys := openSnapFixture(t)
+	defer fsys.Close()
+
+	fi, e := fsys.Lstat("/usr/lib/jvm/default-java")
+	if e != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("got %v, %v for the link", fi, e)
+	}
+	if fi, e = fsys.Stat("/usr/lib/jvm/default-java/lib/server/libjvm.so"); e != nil || fi.Size() != 13288 || !fi.Mode().IsRegular() {
+		t.Errorf("got %v, %v through the link", fi, e)
+	}
+	if fi, e = fsys.Stat("/usr/lib/jvm/temurin-17/bin/java"); e != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("got %v, %v for java", fi, e)
+	}
+	if _, e = fsys.Stat("/usr/lib/jvm/temurin-17/lib/missing"); !os.IsNotExist(e) {
+		t.Errorf("got %v for a missing file", e)
+	}
+	if _, e = fsys.Open("/usr/lib"); e == nil {
+		t.Error("opened a directory")
+	}
+
+	entries, e := fsys.ReadDir("/usr/lib/jvm/temurin-17")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "bin lib release" {
+		t.Errorf("got entries %q", names)
+	}
+
+	// More than the 256 entries of a directory header, whose inodes are
+	// in two metadata blocks
+	if entries, e = fsys.ReadDir("/usr/share/doc"); e != nil || len(entries) != 300 {
+		t.Fatalf("got %d entries, %v", len(entries), e)
+	}
+	for i, entry := range entries {
+		if want := fmt.Sprintf("file%03d", i); entry.Name() != want || entry.Size() != 0 {
+			t.Errorf("got entry %s of %d bytes, want %s", entry.Name(), entry.Size(), want)
+		}
+	}
+}
+
+func TestScanSnap(t *testing.T) {
+	snap := &SnapImage{Name: "jdk", Revision: "1", File: snapFixture}
+	installations, e := ScanSnap(snap, &Config{libjvmFileName: "libjvm.so"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(installations) != 1 {
+		t.Fatalf("got %d installations", len(installations))
+	}
+	inst := installations[0]
+	if inst.LibJVM != snapFixtureLibJVM || inst.Kind != KIND_SNAP || inst.Snap != "jdk" || inst.SnapRevision != "1" {
+		t.Errorf("got %s of %s revision %s", inst.LibJVM, inst.Snap, inst.SnapRevision)
+	}
+	if inst.VersionInfo.Version != "17.0.4.1" || inst.VersionInfo.VMName != "OpenJDK 64-Bit Server VM" {
+		t.Errorf("got version %q of %q", inst.VersionInfo.Version, inst.VersionInfo.VMName)
+	}
+}
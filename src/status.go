@@ -13,6 +13,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,8 +25,14 @@ type Status struct {
 	StartTime int64     `json:"start_time"`
 	EndTime   int64     `json:"end_time"`
 	Args      []string  `json:"args"`
+	Source    string    `json:"source,omitempty"`
 	Error     []string  `json:"error,omitempty"`
+	Warning   string    `json:"warning,omitempty"`
+	Coverage  *Coverage `json:"coverage,omitempty"`
+	Progress  *Progress `json:"progress,omitempty"`
 	Config    *Config   `json:"-"`
+
+	lock sync.Mutex
 }
 
 const (
@@ -34,13 +41,14 @@ const (
 	Terminated StateType = "Terminated"
 	Unknown    StateType = "Unknown"
 	Error      StateType = "Error"
+	Partial    StateType = "Partial"
 )
 
 func NewStatus(config *Config) *Status {
 	hostname, _ := os.Hostname()
 	var args []string
 	if config.command == CMD_START {
-		args = os.Args[1 : len(os.Args)-1]
+		args = config.args
 	}
 
 	s := &Status{
@@ -82,11 +90,14 @@ func ReadStatus(config *Config) *Status {
 }
 
 func (status *Status) SetState(state StateType) {
+	status.lock.Lock()
+	defer status.lock.Unlock()
+
 	switch state {
 	case Running:
 		status.StartTime = time.Now().Unix()
 		break
-	case Finished, Terminated:
+	case Finished, Terminated, Partial:
 		status.EndTime = time.Now().Unix()
 		break
 	case Unknown:
@@ -95,9 +106,48 @@ func (status *Status) SetState(state StateType) {
 	}
 
 	status.State = state
-	f, _ := os.Create(status.Config.StatusFilePath())
+	status.save()
+}
+
+// checkCoverage warns that a finished scan has not seen everything it was
+// asked to, so that it can be told apart from a complete one
+func (status *Status) checkCoverage() {
+	if status.Coverage != nil && status.Coverage.UnreadableDirs > 0 {
+		status.Warning = fmt.Sprintf("incomplete coverage, %d directories could not be read", status.Coverage.UnreadableDirs)
+	}
+}
+
+// UpdateProgress records the progress of a running scan
+func (status *Status) UpdateProgress(progress *Progress) {
+	status.lock.Lock()
+	defer status.lock.Unlock()
+
+	if status.State == Running {
+		status.Progress = progress
+		status.save()
+	}
+}
+
+// save replaces the status file at once, so that it is never seen half
+// written while the scan updates its progress
+func (status *Status) save() {
+	filePath := status.Config.StatusFilePath()
+	f, e := os.Create(filePath + ".tmp")
+	if e != nil {
+		return
+	}
 	txt, _ := json.Marshal(status)
 	_, _ = fmt.Fprintln(f, string(txt))
+	if f.Close() == nil {
+		_ = os.Rename(filePath+".tmp", filePath)
+	}
+}
+
+func formatSeconds(seconds int64) string {
+	if seconds < 0 {
+		return "unknown"
+	}
+	return (time.Duration(seconds) * time.Second).String()
 }
 
 func endTime(status *Status) string {
@@ -115,13 +165,28 @@ func (status *Status) Report() {
 		_ = enc.Encode(status)
 	} else if status.Config.csv {
 		w := csv.NewWriter(os.Stdout)
-		w.Write([]string{"host", "state", "start_time", "end_time", "args"})
-		w.Write([]string{
+		w.Write([]string{"host", "state", "start_time", "end_time", "args", "source",
+			"dirs_visited", "current_path", "installations_found", "errors", "elapsed", "eta", "warning"})
+		record := []string{
 			status.Hostname,
 			string(status.State),
 			time.Unix(status.StartTime, 0).String(),
 			endTime(status),
-			strings.Trim(fmt.Sprint(status.Args), "][")})
+			strings.Trim(fmt.Sprint(status.Args), "]["),
+			status.Source}
+		if p := status.Progress; p != nil {
+			record = append(record,
+				strconv.FormatInt(p.DirsVisited, 10),
+				p.CurrentPath,
+				strconv.FormatInt(p.Found, 10),
+				strconv.FormatInt(p.Errors, 10),
+				strconv.FormatInt(p.Elapsed, 10),
+				strconv.FormatInt(p.ETA, 10))
+		} else {
+			record = append(record, "", "", "", "", "", "")
+		}
+		record = append(record, status.Warning)
+		w.Write(record)
 		w.Flush()
 	} else {
 		fmt.Println("host:", status.Hostname)
@@ -129,9 +194,29 @@ func (status *Status) Report() {
 		fmt.Println("start_time:", time.Unix(status.StartTime, 0).String())
 		fmt.Println("end_time:", endTime(status))
 		fmt.Println("args:", strings.Trim(fmt.Sprint(status.Args), "]["))
+		if status.Source != "" {
+			fmt.Println("source:", status.Source)
+		}
+		if status.Coverage != nil {
+			fmt.Println("coverage:", status.Coverage.Summary())
+		}
+		if p := status.Progress; p != nil {
+			fmt.Println("dirs_visited:", p.DirsVisited)
+			if p.CurrentPath != "" {
+				fmt.Println("current_path:", p.CurrentPath)
+			}
+			fmt.Println("installations_found:", p.Found)
+			fmt.Println("errors:", p.Errors)
+			fmt.Println("elapsed:", formatSeconds(p.Elapsed))
+			if status.State == Running {
+				fmt.Println("eta:", formatSeconds(p.ETA))
+			}
+		}
 		if len(status.Error) > 0 {
 			fmt.Println("error:", strings.Trim(fmt.Sprint(status.Error), "]["))
 		}
+		if status.Warning != "" {
+			fmt.Println("warning:", status.Warning)
+		}
 	}
 }
-
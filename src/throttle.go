@@ -0,0 +1,210 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// I/O scheduling classes of ioprio_set(2)
+const (
+	ioprioClassRT   = 1
+	ioprioClassBE   = 2
+	ioprioClassIdle = 3
+
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// parseIONice parses the -ionice value: idle, be[:level], rt[:level] or
+// just the level of the best-effort class
+func parseIONice(value string) (int, error) {
+	class, level := value, ""
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		class, level = value[:i], value[i+1:]
+	}
+	prio := 4
+	if level != "" {
+		var e error
+		if prio, e = strconv.Atoi(level); e != nil || prio < 0 || prio > 7 {
+			return 0, errors.New("bad -ionice level: " + level)
+		}
+	}
+	switch class {
+	case "idle":
+		return ioprioClassIdle<<ioprioClassShift | 0, nil
+	case "be":
+		return ioprioClassBE<<ioprioClassShift | prio, nil
+	case "rt":
+		return ioprioClassRT<<ioprioClassShift | prio, nil
+	}
+	if prio, e := strconv.Atoi(class); e == nil && level == "" && prio >= 0 && prio <= 7 {
+		return ioprioClassBE<<ioprioClassShift | prio, nil
+	}
+	return 0, errors.New("bad -ionice parameter: " + value)
+}
+
+// applyPriorities lowers (or raises) the CPU and I/O priority of the
+// scanning process. Linux keeps both per thread, so they are applied to
+// every thread that exists already; threads created later and the java
+// processes started for analysis inherit them.
+func applyPriorities(config *Config) error {
+	if !config.setNice && config.ionice < 0 {
+		return nil
+	}
+	tasks, e := ioutil.ReadDir("/proc/self/task")
+	if e != nil {
+		return e
+	}
+	for _, task := range tasks {
+		tid, e := strconv.Atoi(task.Name())
+		if e != nil {
+			continue
+		}
+		if config.setNice {
+			if e := unix.Setpriority(unix.PRIO_PROCESS, tid, config.nice); e != nil {
+				return fmt.Errorf("cannot set -nice %d: %s", config.nice, e.Error())
+			}
+		}
+		if config.ionice >= 0 {
+			if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(config.ionice)); errno != 0 {
+				return fmt.Errorf("cannot set -ionice: %s", errno.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// RateLimiter spreads reads over time so that they do not exceed the
+// given number of bytes per second on average
+type RateLimiter struct {
+	lock sync.Mutex
+	rate float64
+	next time.Time
+}
+
+// ioLimiter throttles the reading of the files being analysed; nil means
+// no throttling
+var ioLimiter *RateLimiter
+
+func NewRateLimiter(bytesPerSecond float64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond}
+}
+
+// Wait blocks until n more bytes may be read
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	wait := l.next.Sub(now)
+	l.lock.Unlock()
+	time.Sleep(wait)
+}
+
+type throttledReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+// throttled wraps r so that reads from it obey the -iorate limit
+func throttled(r io.Reader) io.Reader {
+	if ioLimiter == nil {
+		return r
+	}
+	return &throttledReader{r, ioLimiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, e := t.r.Read(p)
+	t.l.Wait(n)
+	return n, e
+}
+
+// Budget limits the number of files a scan examines and the time it takes.
+// Once exhausted, the scan stops and ends up in the Partial state.
+type Budget struct {
+	files     int64 // accessed atomically, keep 64-bit aligned
+	exhausted int32
+	maxFiles  int64
+	deadline  time.Time
+
+	lock   sync.Mutex
+	reason string
+}
+
+// BudgetError is returned by a scan that was stopped by its budget
+type BudgetError struct {
+	Reason string
+}
+
+func (e *BudgetError) Error() string {
+	return "scan stopped: " + e.Reason
+}
+
+func NewBudget(config *Config) *Budget {
+	b := &Budget{maxFiles: config.maxfiles}
+	if config.timeout > 0 {
+		b.deadline = time.Now().Add(config.timeout)
+	}
+	return b
+}
+
+// CountFile accounts one more examined file and tells whether the scan may
+// go on
+func (b *Budget) CountFile() bool {
+	files := atomic.AddInt64(&b.files, 1)
+	if b.maxFiles > 0 && files > b.maxFiles {
+		b.exhaust(fmt.Sprintf("-maxfiles limit of %d files reached", b.maxFiles))
+	}
+	return !b.Exhausted()
+}
+
+// Exhausted tells whether the scan has to stop
+func (b *Budget) Exhausted() bool {
+	if atomic.LoadInt32(&b.exhausted) != 0 {
+		return true
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		b.exhaust("-timeout expired")
+		return true
+	}
+	return false
+}
+
+func (b *Budget) exhaust(reason string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.reason == "" {
+		b.reason = reason
+	}
+	atomic.StoreInt32(&b.exhausted, 1)
+}
+
+// Err returns the reason of the exhaustion as an error, or nil if the
+// budget is not exhausted
+func (b *Budget) Err() error {
+	if !b.Exhausted() {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return &BudgetError{b.reason}
+}
@@ -7,12 +7,10 @@ package main
 import (
 	"bufio"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
-	"sync"
 )
 
 type JSONArrayWriter struct {
@@ -50,51 +48,6 @@ func (w *JSONArrayWriter) Close() {
 	}
 }
 
-func findFiles(config *Config, callback func(fname string)) error {
-	command := []string{config.root}
-	l := len(config.skipfs)
-	fs := &config.skipfs
-	if l > 0 {
-		command = append(command, "(")
-		for i := 0; i < l-1; i++ {
-			command = append(command, "-fstype", (*fs)[i], "-o")
-		}
-		command = append(command, "-fstype", (*fs)[l-1], ")", "-prune", "-o")
-	}
-	command = append(command, "-xdev", "-type", "f", "-name", config.libjvmFileName, "-print")
-
-	cmd := exec.Command("find", command...)
-
-	stdoutIn, _ := cmd.StdoutPipe()
-	stderrIn, _ := cmd.StderrPipe()
-	e := cmd.Start()
-	if e != nil {
-		return e
-	}
-
-	outputScanner := bufio.NewScanner(bufio.NewReader(stdoutIn))
-	outputScanner.Split(bufio.ScanLines)
-	errorScanner := bufio.NewScanner(bufio.NewReader(stderrIn))
-	errorScanner.Split(bufio.ScanLines)
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		for outputScanner.Scan() {
-			callback(outputScanner.Text())
-		}
-		wg.Done()
-	}()
-
-	for errorScanner.Scan() {
-		// Ignore find errors for now
-		// println("ERROR", errorScanner.Text());
-	}
-	wg.Wait()
-	_ = cmd.Wait()
-	return nil
-}
-
 func closeFile(file *os.File) {
 	_ = file.Close()
 }
@@ -104,23 +57,25 @@ func fileIsEmpty(f *os.File) bool {
 	return e != nil || fileInfo.Size() == 0
 }
 
-func findInUseLibJVM() map[string]int {
+// findInUseLibJVM counts the processes of fsys using each libjvm file, as
+// told by its /proc
+func findInUseLibJVM(fsys FileSystem) map[string]int {
 	res := make(map[string]int)
 
-	procDir, e := ioutil.ReadDir("/proc")
+	procDir, e := fsys.ReadDir("/proc")
 	if e != nil {
 		return res
 	}
 
 	for _, entry := range procDir {
 		if entry.IsDir() {
-			if mapsFile, e := os.Open(path.Join("/proc", entry.Name(), "maps")); e == nil {
+			if mapsFile, e := fsys.Open(path.Join("/proc", entry.Name(), "maps")); e == nil {
 				scan := bufio.NewScanner(mapsFile)
 				for scan.Scan() {
 					str := scan.Text()
 					if strings.Contains(str, "libjvm.so") {
 						if idx := strings.IndexByte(str, '/'); idx > 0 {
-							_, e := os.Stat(str[idx:])
+							_, e := fsys.Stat(str[idx:])
 							if e == nil {
 								res[str[idx:]]++
 							}
@@ -128,9 +83,36 @@ func findInUseLibJVM() map[string]int {
 						break
 					}
 				}
+				_ = mapsFile.Close()
 			}
 		}
 	}
 
 	return res
 }
+
+// inUseLibJVMs holds the libjvm files in use on the host and in the
+// systems mounted at sysroots, each found on first request
+type inUseLibJVMs map[string]map[string]int
+
+func (in inUseLibJVMs) of(sysroot string) map[string]int {
+	res, ok := in[sysroot]
+	if !ok {
+		res = findInUseLibJVM(sysrootFS(sysroot))
+		in[sysroot] = res
+	}
+	return res
+}
+
+// analysisCommand returns a command for analysing an installation. It does
+// not inherit the scan cookie, so that stop terminates the scan before the
+// commands it runs and no half-analysed installation gets reported.
+func analysisCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "SCANJVM_") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	return cmd
+}
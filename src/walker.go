@@ -0,0 +1,177 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+)
+
+// WalkError describes a directory (or file) the walker could not process
+type WalkError struct {
+	Path string
+	Op   string
+	Err  error
+}
+
+func (e *WalkError) Error() string {
+	err := e.Err
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Path, err.Error())
+}
+
+// fileID identifies a directory for loop detection
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// Walker looks for libjvm files, and archives with -archives, below a root
+// directory. A single Walker may be used by several goroutines at once, each
+// walking its own root.
+// Walker leaves out the directories and files for which prune returns true.
+// With -onefs it also never leaves the device of the root it walks, which
+// keeps it out of btrfs subvolumes and the like.
+type Walker struct {
+	config   *Config
+	prune    func(p string, dir bool) bool
+	onFile   func(fname string)
+	onError  func(e error)
+	onDir    func(dir string) // called for every directory entered, if set
+	maxDepth int              // deepest level of entries to examine, unlimited if 0
+	budget   *Budget          // stops the walk once exhausted, if set
+}
+
+func NewWalker(config *Config, prune func(p string, dir bool) bool, onFile func(fname string), onError func(e error)) *Walker {
+	return &Walker{
+		config:  config,
+		prune:   prune,
+		onFile:  onFile,
+		onError: onError,
+	}
+}
+
+// Walk visits root, which is depth levels below the scan root. If pos is
+// set, the walk records its position there and skips what pos tells has
+// been done already.
+func (w *Walker) Walk(root string, depth int, pos *WalkPosition) error {
+	root = path.Clean(root)
+	info, e := os.Stat(root)
+	if e != nil {
+		return &WalkError{root, "stat", e}
+	}
+	id, ok := fileIDOf(info)
+	if !ok {
+		return &WalkError{root, "stat", syscall.EINVAL}
+	}
+	if !info.IsDir() {
+		if info.Mode().IsRegular() && w.isCandidate(info.Name()) && !w.prune(root, false) {
+			w.onFile(root)
+		}
+		return nil
+	}
+
+	if w.maxDepth > 0 && depth >= w.maxDepth {
+		return nil
+	}
+	if pos != nil && pos.isDone() {
+		return nil
+	}
+	if w.walkDir(root, id, nil, depth, pos) && pos != nil {
+		pos.finish()
+	}
+	return nil
+}
+
+// walkDir visits dir, which is depth levels below the scan root, recursively.
+// ancestors holds the identities of all directories on the way from the
+// root to dir and is used to detect loops introduced by bind mounts.
+// It returns false if the walk was cut short by the budget.
+func (w *Walker) walkDir(dir string, id fileID, ancestors []fileID, depth int, pos *WalkPosition) bool {
+	for _, a := range ancestors {
+		if a == id {
+			w.onError(&WalkError{dir, "walk", errLoop})
+			return true
+		}
+	}
+	ancestors = append(ancestors, id)
+	if w.onDir != nil {
+		w.onDir(dir)
+	}
+
+	f, e := os.Open(dir)
+	if e != nil {
+		w.onError(&WalkError{dir, "open", e})
+		return true
+	}
+	entries, e := f.Readdir(-1)
+	closeFile(f)
+	if e != nil {
+		w.onError(&WalkError{dir, "readdir", e})
+		// Continue with whatever has been read so far
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, entry := range entries {
+		p := path.Join(dir, entry.Name())
+		if pos != nil && pos.skip(p) {
+			continue
+		}
+		if w.budget != nil && !w.budget.CountFile() {
+			return false
+		}
+		if !w.walkEntry(p, entry, id, ancestors, depth, pos) {
+			return false
+		}
+		if pos != nil {
+			pos.advance(p)
+		}
+	}
+	return true
+}
+
+// walkEntry visits the entry p of a directory. It returns false if the walk
+// was cut short by the budget.
+func (w *Walker) walkEntry(p string, entry os.FileInfo, dirID fileID, ancestors []fileID, depth int, pos *WalkPosition) bool {
+	mode := entry.Mode()
+	if mode.IsRegular() {
+		if w.isCandidate(entry.Name()) && !w.prune(p, false) {
+			w.onFile(p)
+		}
+		return true
+	}
+	if !mode.IsDir() || (w.maxDepth > 0 && depth+1 >= w.maxDepth) || w.prune(p, true) {
+		return true
+	}
+	childID, ok := fileIDOf(entry)
+	if !ok || (w.config.onefs && childID.dev != dirID.dev) {
+		return true
+	}
+	return w.walkDir(p, childID, ancestors, depth+1, pos)
+}
+
+// isCandidate tells whether a file of the given name is to be analysed:
+// a libjvm, or an archive with -archives
+func (w *Walker) isCandidate(name string) bool {
+	return name == w.config.libjvmFileName || (w.config.archives && isArchive(name))
+}
+
+var errLoop = errors.New("file system loop detected")
+
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+	}
+	return fileID{}, false
+}
@@ -0,0 +1,828 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"math"
+)
+
+// The xz container format
+var (
+	xzMagic       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+)
+
+const xzFilterLZMA2 = 0x21
+
+var (
+	errXZCorrupted   = errors.New("corrupted xz data")
+	errXZUnsupported = errors.New("unsupported xz filter")
+	crc64Table       = crc64.MakeTable(crc64.ECMA)
+)
+
+// xzReader decompresses data in the xz format, as long as it only uses the
+// LZMA2 filter, which is what xz does unless told otherwise. Concatenated
+// streams are decompressed one after the other.
+type xzReader struct {
+	r     *xzInput
+	check byte
+	hash  hash.Hash
+	out   []byte
+	eof   bool
+
+	// The block being decompressed, if any
+	lzma       *lzma2Decoder
+	block      xzBlock
+	blockStart int64
+
+	// The blocks of the stream so far, which its index must list
+	records []xzRecord
+}
+
+// xzBlock holds the sizes of a block, the ones from its header being -1
+// when the header does not tell them
+type xzBlock struct {
+	headerSize       int64
+	compressedSize   int64
+	uncompressedSize int64
+	uncompressed     int64
+}
+
+// xzRecord is the entry of a block in the index
+type xzRecord struct {
+	unpaddedSize     int64
+	uncompressedSize int64
+}
+
+// xzInput reads the compressed data and counts the bytes read, adding them
+// to crc if it is set
+type xzInput struct {
+	br  *bufio.Reader
+	n   int64
+	crc hash.Hash32
+}
+
+func (in *xzInput) ReadByte() (byte, error) {
+	b, e := in.br.ReadByte()
+	if e == nil {
+		in.n++
+		if in.crc != nil {
+			_, _ = in.crc.Write([]byte{b})
+		}
+	}
+	return b, e
+}
+
+func (in *xzInput) readFull(p []byte) error {
+	n, e := io.ReadFull(in.br, p)
+	in.n += int64(n)
+	if in.crc != nil {
+		_, _ = in.crc.Write(p[:n])
+	}
+	if e == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+// readVarint reads a multibyte integer of the xz format
+func (in *xzInput) readVarint() (uint64, error) {
+	var v uint64
+	for i := uint(0); i < 9; i++ {
+		b, e := in.ReadByte()
+		if e != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errXZCorrupted
+}
+
+func newXZReader(r io.Reader) (io.Reader, error) {
+	x := &xzReader{r: &xzInput{br: bufio.NewReader(r)}}
+	if e := x.readStreamHeader(); e != nil {
+		return nil, e
+	}
+	return x, nil
+}
+
+func (x *xzReader) Read(p []byte) (int, error) {
+	for len(x.out) == 0 {
+		if x.eof {
+			return 0, io.EOF
+		}
+		if e := x.next(); e != nil {
+			return 0, e
+		}
+	}
+	n := copy(p, x.out)
+	x.out = x.out[n:]
+	return n, nil
+}
+
+func (x *xzReader) readStreamHeader() error {
+	h := make([]byte, 12)
+	if e := x.r.readFull(h); e != nil {
+		return e
+	}
+	if !bytes.Equal(h[:6], xzMagic) || h[6] != 0 || h[7] > 0x0f {
+		return errXZCorrupted
+	}
+	if crc32.ChecksumIEEE(h[6:8]) != binary.LittleEndian.Uint32(h[8:]) {
+		return errXZCorrupted
+	}
+	x.check = h[7]
+	x.records = nil
+	return nil
+}
+
+// checkSize returns the size of the check of every block
+func (x *xzReader) checkSize() int {
+	if x.check == 0 {
+		return 0
+	}
+	return 4 << ((x.check - 1) / 3)
+}
+
+func (x *xzReader) newCheck() hash.Hash {
+	switch x.check {
+	case 0x01:
+		return crc32.NewIEEE()
+	case 0x04:
+		return crc64.New(crc64Table)
+	case 0x0a:
+		return sha256.New()
+	}
+	return nil
+}
+
+// next decompresses the next chunk of a block
+func (x *xzReader) next() error {
+	if x.lzma == nil {
+		start := x.r.n
+		b, e := x.r.ReadByte()
+		if e != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if b == 0 {
+			// The index follows the last block of the stream
+			if e = x.readIndex(start); e != nil {
+				return e
+			}
+			return x.nextStream()
+		}
+		if x.lzma, e = x.readBlockHeader(b); e != nil {
+			return e
+		}
+		x.block.headerSize = x.r.n - start
+		x.blockStart = x.r.n
+		x.hash = x.newCheck()
+	}
+
+	out, e := x.lzma.decodeChunk(x.r)
+	if e != nil {
+		return e
+	}
+	if out != nil {
+		if x.hash != nil {
+			_, _ = x.hash.Write(out)
+		}
+		x.block.uncompressed += int64(len(out))
+		x.out = out
+		return nil
+	}
+
+	// The block ends with padding and its check, and has the sizes its
+	// header tells
+	compressed := x.r.n - x.blockStart
+	if x.block.compressedSize >= 0 && x.block.compressedSize != compressed ||
+		x.block.uncompressedSize >= 0 && x.block.uncompressedSize != x.block.uncompressed {
+		return errXZCorrupted
+	}
+	for (x.r.n-x.blockStart)%4 != 0 {
+		if b, e := x.r.ReadByte(); e != nil || b != 0 {
+			return errXZCorrupted
+		}
+	}
+	check := make([]byte, x.checkSize())
+	if e := x.r.readFull(check); e != nil {
+		return e
+	}
+	if x.hash != nil {
+		sum := x.hash.Sum(nil)
+		if x.check == 0x04 {
+			// CRC64 is stored little endian
+			for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+				sum[i], sum[j] = sum[j], sum[i]
+			}
+		} else if x.check == 0x01 {
+			binary.LittleEndian.PutUint32(sum, x.hash.(hash.Hash32).Sum32())
+		}
+		if !bytes.Equal(sum, check) {
+			return errXZCorrupted
+		}
+	}
+	x.records = append(x.records, xzRecord{
+		unpaddedSize:     x.block.headerSize + compressed + int64(len(check)),
+		uncompressedSize: x.block.uncompressed,
+	})
+	x.lzma = nil
+	return nil
+}
+
+func (x *xzReader) readBlockHeader(sizeByte byte) (*lzma2Decoder, error) {
+	h := make([]byte, (int(sizeByte)+1)*4)
+	h[0] = sizeByte
+	if e := x.r.readFull(h[1:]); e != nil {
+		return nil, e
+	}
+	if crc32.ChecksumIEEE(h[:len(h)-4]) != binary.LittleEndian.Uint32(h[len(h)-4:]) {
+		return nil, errXZCorrupted
+	}
+	flags := h[1]
+	if flags&0x3c != 0 || flags&0x03 != 0 {
+		// Reserved bits, or more than one filter
+		return nil, errXZUnsupported
+	}
+	in := &xzInput{br: bufio.NewReader(bytes.NewReader(h[2 : len(h)-4]))}
+	x.block = xzBlock{compressedSize: -1, uncompressedSize: -1}
+	if flags&0x40 != 0 {
+		size, e := in.readVarint()
+		if e != nil || size == 0 || size > math.MaxInt64 {
+			return nil, errXZCorrupted
+		}
+		x.block.compressedSize = int64(size)
+	}
+	if flags&0x80 != 0 {
+		size, e := in.readVarint()
+		if e != nil || size > math.MaxInt64 {
+			return nil, errXZCorrupted
+		}
+		x.block.uncompressedSize = int64(size)
+	}
+	id, e := in.readVarint()
+	if e != nil {
+		return nil, errXZCorrupted
+	}
+	propsSize, e := in.readVarint()
+	if e != nil {
+		return nil, errXZCorrupted
+	}
+	if id != xzFilterLZMA2 || propsSize != 1 {
+		return nil, errXZUnsupported
+	}
+	props, e := in.ReadByte()
+	if e != nil || props > 40 {
+		return nil, errXZCorrupted
+	}
+	dictSize := uint32(0xffffffff)
+	if props < 40 {
+		dictSize = (2 | uint32(props&1)) << (props/2 + 11)
+	}
+	return newLZMA2Decoder(dictSize), nil
+}
+
+// readIndex reads the index, whose indicator starts at start, and the
+// stream footer. The index must list the blocks that were read.
+func (x *xzReader) readIndex(start int64) error {
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write([]byte{0})
+	x.r.crc = crc
+	defer func() { x.r.crc = nil }()
+
+	records, e := x.r.readVarint()
+	if e != nil {
+		return e
+	}
+	if records != uint64(len(x.records)) {
+		return errXZCorrupted
+	}
+	for _, record := range x.records {
+		unpadded, e := x.r.readVarint()
+		if e != nil {
+			return e
+		}
+		uncompressed, e := x.r.readVarint()
+		if e != nil {
+			return e
+		}
+		if unpadded != uint64(record.unpaddedSize) || uncompressed != uint64(record.uncompressedSize) {
+			return errXZCorrupted
+		}
+	}
+	for (x.r.n-start)%4 != 0 {
+		if b, e := x.r.ReadByte(); e != nil || b != 0 {
+			return errXZCorrupted
+		}
+	}
+	x.r.crc = nil
+	sum := make([]byte, 4)
+	if e = x.r.readFull(sum); e != nil {
+		return e
+	}
+	if binary.LittleEndian.Uint32(sum) != crc.Sum32() {
+		return errXZCorrupted
+	}
+	indexSize := x.r.n - start
+
+	// The footer tells the size of the index and the flags of the header
+	footer := make([]byte, 12)
+	if e = x.r.readFull(footer); e != nil {
+		return e
+	}
+	if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer) {
+		return errXZCorrupted
+	}
+	backwardSize := (int64(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+	if backwardSize != indexSize || footer[8] != 0 || footer[9] != x.check ||
+		!bytes.Equal(footer[10:], xzFooterMagic) {
+		return errXZCorrupted
+	}
+	return nil
+}
+
+// nextStream skips the stream padding and reads the header of the next
+// stream, if any
+func (x *xzReader) nextStream() error {
+	for {
+		b, e := x.r.br.Peek(1)
+		if e == io.EOF {
+			x.eof = true
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		if b[0] != 0 {
+			return x.readStreamHeader()
+		}
+		_, _ = x.r.ReadByte()
+	}
+}
+
+// LZMA parameters
+const (
+	lzmaNumStates        = 12
+	lzmaNumPosBitsMax    = 4
+	lzmaNumLenToPosState = 4
+	lzmaEndPosModelIndex = 14
+	lzmaNumFullDistances = 128
+	lzmaNumAlignBits     = 4
+	lzmaMatchMinLen      = 2
+	lzmaProbInit         = 1024
+)
+
+// lzma2Decoder decodes the LZMA2 chunks of a block
+type lzma2Decoder struct {
+	// Dictionary, which grows up to dictSize as the data is decoded
+	window    []byte
+	dictSize  int
+	pos       int // position in the window
+	total     int // bytes decoded since the dictionary was reset
+	needDict  bool
+	needProps bool
+
+	rc    rangeDecoder
+	lc    uint
+	lp    uint
+	pb    uint
+	state int
+	reps  [4]uint32
+
+	literal    []uint16
+	isMatch    [lzmaNumStates << lzmaNumPosBitsMax]uint16
+	isRep      [lzmaNumStates]uint16
+	isRepG0    [lzmaNumStates]uint16
+	isRepG1    [lzmaNumStates]uint16
+	isRepG2    [lzmaNumStates]uint16
+	isRep0Long [lzmaNumStates << lzmaNumPosBitsMax]uint16
+	posSlot    [lzmaNumLenToPosState][1 << 6]uint16
+	posSpecial [1 + lzmaNumFullDistances - lzmaEndPosModelIndex]uint16
+	align      [1 << lzmaNumAlignBits]uint16
+	lenDec     lzmaLenDecoder
+	repLenDec  lzmaLenDecoder
+
+	out []byte
+}
+
+type lzmaLenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << lzmaNumPosBitsMax][1 << 3]uint16
+	mid     [1 << lzmaNumPosBitsMax][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func newLZMA2Decoder(dictSize uint32) *lzma2Decoder {
+	// The window starts small, as small archives are common, and grows
+	// with the data up to the dictionary size
+	if dictSize < 4096 {
+		dictSize = 4096
+	}
+	if dictSize > 1<<30 {
+		dictSize = 1 << 30
+	}
+	window := 1 << 16
+	if window > int(dictSize) {
+		window = int(dictSize)
+	}
+	return &lzma2Decoder{window: make([]byte, window), dictSize: int(dictSize), needDict: true, needProps: true}
+}
+
+// decodeChunk decodes the next chunk. It returns nil at the end of the
+// LZMA2 data.
+func (d *lzma2Decoder) decodeChunk(in *xzInput) ([]byte, error) {
+	control, e := in.ReadByte()
+	if e != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if control == 0x00 {
+		return nil, nil
+	}
+	if control == 0x01 || control == 0x02 {
+		// Uncompressed chunk, resetting the dictionary first for 0x01
+		if control == 0x01 {
+			d.resetDict()
+		} else if d.needDict {
+			return nil, errXZCorrupted
+		}
+		var size [2]byte
+		if e = in.readFull(size[:]); e != nil {
+			return nil, e
+		}
+		data := make([]byte, int(binary.BigEndian.Uint16(size[:]))+1)
+		if e = in.readFull(data); e != nil {
+			return nil, e
+		}
+		for _, b := range data {
+			d.putByte(b)
+		}
+		return data, nil
+	}
+	if control < 0x80 {
+		return nil, errXZCorrupted
+	}
+
+	var h [4]byte
+	if e = in.readFull(h[:]); e != nil {
+		return nil, e
+	}
+	uncompressed := int(control&0x1f)<<16 + int(binary.BigEndian.Uint16(h[0:])) + 1
+	compressed := int(binary.BigEndian.Uint16(h[2:])) + 1
+	switch reset := (control >> 5) & 0x03; {
+	case reset == 3:
+		d.resetDict()
+		fallthrough
+	case reset == 2:
+		props, e := in.ReadByte()
+		if e != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if e = d.setProps(props); e != nil {
+			return nil, e
+		}
+		fallthrough
+	case reset == 1:
+		d.resetState()
+	}
+	if d.needDict || d.needProps {
+		return nil, errXZCorrupted
+	}
+
+	data := make([]byte, compressed)
+	if e = in.readFull(data); e != nil {
+		return nil, e
+	}
+	if !d.rc.init(data) {
+		return nil, errXZCorrupted
+	}
+	d.out = make([]byte, 0, uncompressed)
+	if e = d.decode(uncompressed); e != nil {
+		return nil, e
+	}
+	// The range coder is flushed at the end of each chunk
+	if d.rc.corrupted || d.rc.pos != len(data) || d.rc.code != 0 {
+		return nil, errXZCorrupted
+	}
+	return d.out, nil
+}
+
+func (d *lzma2Decoder) resetDict() {
+	d.pos, d.total, d.needDict = 0, 0, false
+}
+
+func (d *lzma2Decoder) setProps(props byte) error {
+	if props >= 9*5*5 {
+		return errXZCorrupted
+	}
+	d.lc = uint(props % 9)
+	props /= 9
+	d.lp = uint(props % 5)
+	d.pb = uint(props / 5)
+	if d.lc+d.lp > 4 {
+		return errXZCorrupted
+	}
+	d.literal = make([]uint16, 0x300<<(d.lc+d.lp))
+	d.needProps = false
+	return nil
+}
+
+func (d *lzma2Decoder) resetState() {
+	d.state = 0
+	d.reps = [4]uint32{}
+	for i := range d.literal {
+		d.literal[i] = lzmaProbInit
+	}
+	initProbs(d.isMatch[:], d.isRep[:], d.isRepG0[:], d.isRepG1[:], d.isRepG2[:], d.isRep0Long[:], d.posSpecial[:], d.align[:])
+	for i := range d.posSlot {
+		initProbs(d.posSlot[i][:])
+	}
+	d.lenDec.reset()
+	d.repLenDec.reset()
+}
+
+func initProbs(probs ...[]uint16) {
+	for _, p := range probs {
+		for i := range p {
+			p[i] = lzmaProbInit
+		}
+	}
+}
+
+func (l *lzmaLenDecoder) reset() {
+	l.choice, l.choice2 = lzmaProbInit, lzmaProbInit
+	for i := range l.low {
+		initProbs(l.low[i][:], l.mid[i][:])
+	}
+	initProbs(l.high[:])
+}
+
+func (l *lzmaLenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&l.choice) == 0 {
+		return rc.bitTree(l.low[posState][:], 3)
+	}
+	if rc.bit(&l.choice2) == 0 {
+		return 8 + rc.bitTree(l.mid[posState][:], 3)
+	}
+	return 16 + rc.bitTree(l.high[:], 8)
+}
+
+func (d *lzma2Decoder) putByte(b byte) {
+	d.window[d.pos] = b
+	if d.pos++; d.pos == len(d.window) {
+		if len(d.window) < d.dictSize && d.total < len(d.window) {
+			// Nothing has wrapped around yet
+			size := 2 * len(d.window)
+			if size > d.dictSize {
+				size = d.dictSize
+			}
+			d.window = append(d.window, make([]byte, size-len(d.window))...)
+		} else {
+			d.pos = 0
+		}
+	}
+	d.total++
+	d.out = append(d.out, b)
+}
+
+// getByte returns the byte dist bytes back, with dist >= 1
+func (d *lzma2Decoder) getByte(dist uint32) byte {
+	i := d.pos - int(dist)
+	if i < 0 {
+		i += len(d.window)
+	}
+	return d.window[i]
+}
+
+// decode decodes size bytes of an LZMA chunk
+func (d *lzma2Decoder) decode(size int) error {
+	rc := &d.rc
+	posMask := uint32(1)<<d.pb - 1
+	for produced := 0; produced < size; {
+		if rc.corrupted {
+			return errXZCorrupted
+		}
+		posState := uint32(d.total) & posMask
+		if rc.bit(&d.isMatch[d.state<<lzmaNumPosBitsMax+int(posState)]) == 0 {
+			d.decodeLiteral()
+			produced++
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[d.state]) == 0 {
+			d.reps[3], d.reps[2], d.reps[1] = d.reps[2], d.reps[1], d.reps[0]
+			length = d.lenDec.decode(rc, posState)
+			if d.state < 7 {
+				d.state = 7
+			} else {
+				d.state = 10
+			}
+			d.reps[0] = d.decodeDistance(length)
+			if d.reps[0] == 0xffffffff {
+				// End marker, which LZMA2 has no use for
+				return errXZCorrupted
+			}
+		} else {
+			if d.total == 0 {
+				return errXZCorrupted
+			}
+			if rc.bit(&d.isRepG0[d.state]) == 0 {
+				if rc.bit(&d.isRep0Long[d.state<<lzmaNumPosBitsMax+int(posState)]) == 0 {
+					// Short rep: a single byte
+					if d.state < 7 {
+						d.state = 9
+					} else {
+						d.state = 11
+					}
+					d.putByte(d.getByte(d.reps[0] + 1))
+					produced++
+					continue
+				}
+			} else {
+				var dist uint32
+				if rc.bit(&d.isRepG1[d.state]) == 0 {
+					dist = d.reps[1]
+				} else {
+					if rc.bit(&d.isRepG2[d.state]) == 0 {
+						dist = d.reps[2]
+					} else {
+						dist = d.reps[3]
+						d.reps[3] = d.reps[2]
+					}
+					d.reps[2] = d.reps[1]
+				}
+				d.reps[1] = d.reps[0]
+				d.reps[0] = dist
+			}
+			length = d.repLenDec.decode(rc, posState)
+			if d.state < 7 {
+				d.state = 8
+			} else {
+				d.state = 11
+			}
+		}
+
+		length += lzmaMatchMinLen
+		dist := d.reps[0] + 1
+		if int(dist) > d.total || int(dist) > len(d.window) || produced+int(length) > size {
+			return errXZCorrupted
+		}
+		for i := uint32(0); i < length; i++ {
+			d.putByte(d.getByte(dist))
+		}
+		produced += int(length)
+	}
+	return nil
+}
+
+func (d *lzma2Decoder) decodeLiteral() {
+	rc := &d.rc
+	prevByte := uint32(0)
+	if d.total > 0 {
+		prevByte = uint32(d.getByte(1))
+	}
+	litState := (uint32(d.total)&(1<<d.lp-1))<<d.lc + prevByte>>(8-d.lc)
+	probs := d.literal[0x300*litState:]
+	symbol := uint32(1)
+	if d.state >= 7 {
+		matchByte := uint32(d.getByte(d.reps[0] + 1))
+		for symbol < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			bit := rc.bit(&probs[(1+matchBit)<<8+symbol])
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | rc.bit(&probs[symbol])
+	}
+	d.putByte(byte(symbol))
+
+	switch {
+	case d.state < 4:
+		d.state = 0
+	case d.state < 10:
+		d.state -= 3
+	default:
+		d.state -= 6
+	}
+}
+
+func (d *lzma2Decoder) decodeDistance(length uint32) uint32 {
+	rc := &d.rc
+	lenState := length
+	if lenState > lzmaNumLenToPosState-1 {
+		lenState = lzmaNumLenToPosState - 1
+	}
+	posSlot := rc.bitTree(d.posSlot[lenState][:], 6)
+	if posSlot < 4 {
+		return posSlot
+	}
+	numDirectBits := uint(posSlot>>1) - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < lzmaEndPosModelIndex {
+		return dist + rc.reverseBitTree(d.posSpecial[dist-posSlot:], numDirectBits)
+	}
+	dist += rc.direct(numDirectBits-lzmaNumAlignBits) << lzmaNumAlignBits
+	return dist + rc.reverseBitTree(d.align[:], lzmaNumAlignBits)
+}
+
+// rangeDecoder decodes the bits of one LZMA chunk
+type rangeDecoder struct {
+	data      []byte
+	pos       int
+	rng       uint32
+	code      uint32
+	corrupted bool
+}
+
+func (rc *rangeDecoder) init(data []byte) bool {
+	if len(data) < 5 || data[0] != 0 {
+		return false
+	}
+	rc.data, rc.pos, rc.corrupted = data, 5, false
+	rc.rng = 0xffffffff
+	rc.code = binary.BigEndian.Uint32(data[1:])
+	return rc.code != rc.rng
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		if rc.pos >= len(rc.data) {
+			rc.corrupted = true
+			return
+		}
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.data[rc.pos])
+		rc.pos++
+	}
+}
+
+func (rc *rangeDecoder) bit(prob *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*prob)
+	var bit uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*prob += (1<<11 - *prob) >> 5
+	} else {
+		rc.rng -= bound
+		rc.code -= bound
+		*prob -= *prob >> 5
+		bit = 1
+	}
+	rc.normalize()
+	return bit
+}
+
+func (rc *rangeDecoder) direct(numBits uint) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		if rc.code == rc.rng {
+			rc.corrupted = true
+		}
+		rc.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (rc *rangeDecoder) bitTree(probs []uint16, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 | rc.bit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func (rc *rangeDecoder) reverseBitTree(probs []uint16, numBits uint) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := uint(0); i < numBits; i++ {
+		bit := rc.bit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
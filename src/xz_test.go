@@ -0,0 +1,90 @@
+// Copyright 2021 Azul Systems, Inc. All rights reserved.
+// Use of this source code is governed by the 3-Clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// The fixtures of testdata/xz are plain.bin compressed by xz 5.6 with:
+//
+//	crc64.xz        xz
+//	multiblock.xz   xz -C crc32 --block-size=2048
+//	sha256.xz       xz -C sha256
+//	nocheck.xz      xz -C none
+//	props.xz        xz --lzma2=preset=6,lc=0,lp=2,pb=0
+//	multistream.xz  two streams, of its first 6000 bytes and of the rest,
+//	                with 4 bytes of stream padding between them
+//	x86.xz          xz --x86 --lzma2
+//
+// plain.bin has 2048 random bytes in the middle of text, which LZMA2 keeps
+// in uncompressed chunks.
+func readXZFixture(t *testing.T, name string) []byte {
+	data, e := ioutil.ReadFile("testdata/xz/" + name)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return data
+}
+
+func decompressXZ(data []byte) (out []byte, e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	r, e := newXZReader(bytes.NewReader(data))
+	if e != nil {
+		return nil, e
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestXZReader(t *testing.T) {
+	plain := readXZFixture(t, "plain.bin")
+	for _, name := range []string{"crc64.xz", "multiblock.xz", "sha256.xz", "nocheck.xz", "props.xz", "multistream.xz"} {
+		out, e := decompressXZ(readXZFixture(t, name))
+		if e != nil {
+			t.Errorf("%s: %v", name, e)
+			continue
+		}
+		if !bytes.Equal(out, plain) {
+			t.Errorf("%s: got %d bytes differing from the %d of plain.bin", name, len(out), len(plain))
+		}
+	}
+}
+
+func TestXZReaderUnsupportedFilter(t *testing.T) {
+	if _, e := decompressXZ(readXZFixture(t, "x86.xz")); e != errXZUnsupported {
+		t.Errorf("got error %v, want %v", e, errXZUnsupported)
+	}
+}
+
+func TestXZReaderTruncated(t *testing.T) {
+	data := readXZFixture(t, "multiblock.xz")
+	for n := 0; n < len(data); n++ {
+		if _, e := decompressXZ(data[:n]); e == nil {
+			t.Errorf("cut at %d: got no error", n)
+		}
+	}
+}
+
+// Every byte of the compressed data is covered by a check, so that no
+// corruption goes unnoticed, and none may make the reader panic
+func TestXZReaderCorrupted(t *testing.T) {
+	for _, name := range []string{"crc64.xz", "multiblock.xz", "multistream.xz"} {
+		data := readXZFixture(t, name)
+		for i := range data {
+			corrupted := append([]byte(nil), data...)
+			corrupted[i] ^= 0x55
+			if _, e := decompressXZ(corrupted); e == nil {
+				t.Errorf("%s: byte %d corrupted: got no error", name, i)
+			}
+		}
+	}
+}